@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrBranchInactive = errors.New("core: branch is not active")
+	ErrBranchMismatch = errors.New("core: operator and till belong to different branches")
+)
+
+// DefaultBranchId is the branch created by the migrations, which every operator, till and record made before branches
+// existed belongs to. Its system accounts are derived from the root namespace, so they are the accounts a single-branch
+// deployment has always used.
+var DefaultBranchId = uuid.Must(uuid.FromString("00000000-0000-0000-0000-000000000001"))
+
+// Branch is a shop with its own safe, tills, and overs, shorts, control and fees accounts in TB.
+// Customers are shared between branches.
+type Branch struct {
+	Id        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+	IsActive  bool
+}
+
+// branchNamespace derives a branch's namespace for idWithNamespace from the root namespace.
+func (c *Core) branchNamespace(branchId uuid.UUID) uuid.UUID {
+	if branchId == DefaultBranchId {
+		return c.namespace
+	}
+	return uuid.NewV5(c.namespace, "branch_"+branchId.String())
+}
+
+// branchIds gets a branch's system account IDs, ensuring the accounts exist in TB the first time the branch is used by
+// this Core.
+func (c *Core) branchIds(ctx context.Context, branchId uuid.UUID) (*knownIds, error) {
+	c.branchAccountsMu.Lock()
+	defer c.branchAccountsMu.Unlock()
+
+	if ids, ok := c.branchAccounts[branchId]; ok {
+		return ids, nil
+	}
+
+	var generation int
+	if err := c.pgc.QueryRow(ctx, "SELECT liquidity_generation FROM branches WHERE id = $1", branchId).
+		Scan(&generation); err != nil {
+		return nil, err
+	}
+
+	ids, err := initSystemAccounts(
+		c.options,
+		c.branchNamespace(branchId),
+		generation,
+		c.tbc,
+		c.Logger.With("branch_id", branchId),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.branchAccounts[branchId] = ids
+	return ids, nil
+}
+
+// CreateBranch inserts a new branch into the database and creates its system accounts in TB.
+// New branches start on guarded liquidity accounts, which TB refuses to overdraw.
+func (c *Core) CreateBranch(ctx context.Context, name string) (*Branch, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	branch := &Branch{Id: id, Name: name}
+	if err := c.pgc.QueryRow(
+		ctx,
+		"INSERT INTO branches (id, name, liquidity_generation) VALUES ($1, $2, $3) RETURNING created_at, is_active",
+		id,
+		name,
+		guardedLiquidityGeneration,
+	).Scan(&branch.CreatedAt, &branch.IsActive); err != nil {
+		return nil, err
+	}
+	if _, err := c.branchIds(ctx, id); err != nil {
+		return nil, err
+	}
+
+	c.Logger.Info("branch created", "branch_id", id, "name", name)
+	return branch, nil
+}
+
+// GetBranch queries the database for a branch by id.
+func (c *Core) GetBranch(ctx context.Context, id uuid.UUID) (*Branch, error) {
+	branch := &Branch{}
+	if err := c.pgc.QueryRow(ctx, "SELECT id, name, created_at, is_active FROM branches WHERE id = $1", id).
+		Scan(&branch.Id, &branch.Name, &branch.CreatedAt, &branch.IsActive); err != nil {
+		return nil, err
+	}
+
+	return branch, nil
+}
+
+// checkBranchActive returns ErrBranchInactive if a branch has been deactivated.
+func (c *Core) checkBranchActive(ctx context.Context, branchId uuid.UUID) error {
+	branch, err := c.GetBranch(ctx, branchId)
+	if err != nil {
+		return err
+	}
+	if !branch.IsActive {
+		return ErrBranchInactive
+	}
+	return nil
+}
+
+// ListBranches gets every branch, active or not, ordered by name.
+func (c *Core) ListBranches(ctx context.Context) ([]Branch, error) {
+	rows, err := c.pgc.Query(ctx, "SELECT id, name, created_at, is_active FROM branches ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Branch, error) {
+		var b Branch
+		err := row.Scan(&b.Id, &b.Name, &b.CreatedAt, &b.IsActive)
+		return b, err
+	})
+}
+
+// SetBranchActivated sets the is_active field of a branch to the given value. Inactive branches can't open ledgers, trade,
+// move float into tills, send or be sent stock, or have tills created or operators moved to them.
+func (c *Core) SetBranchActivated(ctx context.Context, id uuid.UUID, isActive bool) error {
+	if _, err := c.pgc.Exec(
+		ctx,
+		"UPDATE branches SET is_active = $1 WHERE id = $2",
+		isActive,
+		id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// nullableId returns nil for a Nil id, for filters where that means any, e.g. every branch.
+func nullableId(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
+
+// BranchPosition is a branch's posted TB balances in one ledger, in the ledger's minor unit.
+type BranchPosition struct {
+	BranchId uuid.UUID // Nil for positions consolidated across branches
+	Ledger   Ledger
+	Safe     int64
+	Tills    int64 // Summed over every till in the branch
+	Overs    int64 // Summed over the branch and its tills
+	Shorts   int64 // Summed over the branch and its tills
+	Fees     int64 // Only in the local currency ledger
+	// InTransit is stock dispatched by the branch, or ordered from a supplier, that has not yet been received.
+	InTransit int64
+}
+
+// GetBranchPositions gets the posted balances of every ledger with any activity in a branch, or in every branch if branchId
+// is Nil. Use ConsolidatePositions to total positions across branches.
+func (c *Core) GetBranchPositions(ctx context.Context, branchId uuid.UUID) ([]BranchPosition, error) {
+	var branches []Branch
+	if branchId == uuid.Nil {
+		var err error
+		branches, err = c.ListBranches(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		branch, err := c.GetBranch(ctx, branchId)
+		if err != nil {
+			return nil, err
+		}
+		branches = []Branch{*branch}
+	}
+
+	positions := []BranchPosition{}
+	for _, branch := range branches {
+		ids, err := c.branchIds(ctx, branch.Id)
+		if err != nil {
+			return nil, err
+		}
+		tills, err := c.ListTills(ctx, branch.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		byLedger := map[Ledger]*BranchPosition{}
+		position := func(ledger Ledger) *BranchPosition {
+			p, ok := byLedger[ledger]
+			if !ok {
+				p = &BranchPosition{BranchId: branch.Id, Ledger: ledger}
+				byLedger[ledger] = p
+			}
+			return p
+		}
+
+		accountIds := []tbTypes.Uint128{ids.fees}
+		for ledger := range CurrencyAssetScales {
+			accountIds = append(
+				accountIds,
+				ids.liquidity[ledger],
+				ids.overs[ledger],
+				ids.shorts[ledger],
+				ids.inTransit[ledger],
+			)
+			for _, till := range tills {
+				t := c.tillAccountIds(ids, till.Id, ledger)
+				accountIds = append(accountIds, t.liquidity, t.overs, t.shorts)
+			}
+		}
+		accounts, err := c.lookupAccounts(accountIds)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, account := range accounts {
+			if AccountCode(account.Code) == AccountCodeBranchInTransit {
+				if pending := account.DebitsPending.BigInt(); pending.Sign() != 0 {
+					position(Ledger(account.Ledger)).InTransit += pending.Int64()
+				}
+				continue
+			}
+
+			balance := liquidityBalance(account)
+			if balance == 0 {
+				continue
+			}
+			p := position(Ledger(account.Ledger))
+			switch AccountCode(account.Code) {
+			case AccountCodeBranchLiquidity:
+				p.Safe += balance
+			case AccountCodeTillLiquidity:
+				p.Tills += balance
+			case AccountCodeBranchOvers, AccountCodeTillOvers:
+				p.Overs -= balance
+			case AccountCodeBranchShorts, AccountCodeTillShorts:
+				p.Shorts += balance
+			case AccountCodeBranchFees:
+				p.Fees -= balance
+			}
+		}
+
+		for _, ledger := range slices.Sorted(maps.Keys(byLedger)) {
+			positions = append(positions, *byLedger[ledger])
+		}
+	}
+
+	return positions, nil
+}
+
+// ConsolidatePositions totals positions from several branches into one position per ledger.
+func ConsolidatePositions(positions []BranchPosition) []BranchPosition {
+	byLedger := map[Ledger]int{}
+	consolidated := []BranchPosition{}
+	for _, p := range positions {
+		i, ok := byLedger[p.Ledger]
+		if !ok {
+			i = len(consolidated)
+			byLedger[p.Ledger] = i
+			consolidated = append(consolidated, BranchPosition{Ledger: p.Ledger})
+		}
+		consolidated[i].Safe += p.Safe
+		consolidated[i].Tills += p.Tills
+		consolidated[i].Overs += p.Overs
+		consolidated[i].Shorts += p.Shorts
+		consolidated[i].Fees += p.Fees
+		consolidated[i].InTransit += p.InTransit
+	}
+
+	return consolidated
+}
+
+// tbLookupBatchMax is the most IDs TB accepts in one lookup request.
+const tbLookupBatchMax = 8189
+
+// lookupAccounts looks up any number of accounts in TB, split into as many requests as needed.
+// Accounts that don't exist are left out.
+func (c *Core) lookupAccounts(ids []tbTypes.Uint128) ([]tbTypes.Account, error) {
+	accounts := make([]tbTypes.Account, 0, len(ids))
+	for start := 0; start < len(ids); start += tbLookupBatchMax {
+		batch, err := c.tbc.LookupAccounts(ids[start:min(start+tbLookupBatchMax, len(ids))])
+		if err != nil {
+			return nil, fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+		}
+		accounts = append(accounts, batch...)
+	}
+
+	return accounts, nil
+}
+
+// lookupTransfers looks up any number of transfers in TB, split into as many requests as needed.
+// Transfers that don't exist are left out.
+func (c *Core) lookupTransfers(ids []tbTypes.Uint128) ([]tbTypes.Transfer, error) {
+	transfers := make([]tbTypes.Transfer, 0, len(ids))
+	for start := 0; start < len(ids); start += tbLookupBatchMax {
+		batch, err := c.tbc.LookupTransfers(ids[start:min(start+tbLookupBatchMax, len(ids))])
+		if err != nil {
+			return nil, fmt.Errorf("core: failed to send lookup transfers request to TB: %w", err)
+		}
+		transfers = append(transfers, batch...)
+	}
+
+	return transfers, nil
+}
@@ -0,0 +1,390 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrCustomerBlocked = errors.New("core: customer is blocked")
+	ErrIdRequired      = errors.New("core: customer must have valid, unexpired ID on file")
+	ErrEddRequired     = errors.New("core: customer must have a current enhanced due diligence review")
+	ErrInvalidEdd      = errors.New("core: an enhanced due diligence review must record the customer's source of funds")
+)
+
+// DefaultEddValidity is used when Options.Cdd.EddValidity is not set.
+const DefaultEddValidity = 365 * 24 * time.Hour
+
+// CddPolicy holds the customer due diligence thresholds checked before every trade. All amounts are in the local currency.
+type CddPolicy struct {
+	// Id is where customers must have valid, unexpired ID on file; see AddCustomerDocument.
+	Id CddThresholds
+	// Edd is where customers must have a current enhanced due diligence review; see RecordEddReview.
+	Edd CddThresholds
+	// EddValidity is how long an EDD review lasts before it must be repeated. Defaults to DefaultEddValidity.
+	EddValidity time.Duration
+}
+
+// CddThresholds are local currency amounts that, once exceeded by a single trade or by a customer's total over a rolling
+// window including the trade, bring in a CDD requirement. Zero thresholds are never exceeded.
+type CddThresholds struct {
+	SingleTrade decimal.Decimal
+	Rolling30   decimal.Decimal
+	Rolling90   decimal.Decimal
+	Rolling365  decimal.Decimal
+}
+
+// cddWindow pairs a threshold with the rolling window it applies to. A zero window is the single trade.
+type cddWindow struct {
+	window    time.Duration
+	threshold decimal.Decimal
+}
+
+func (t CddThresholds) windows() []cddWindow {
+	return []cddWindow{
+		{0, t.SingleTrade},
+		{30 * 24 * time.Hour, t.Rolling30},
+		{90 * 24 * time.Hour, t.Rolling90},
+		{365 * 24 * time.Hour, t.Rolling365},
+	}
+}
+
+// CddError is returned when a trade is refused because the customer has not met a CDD requirement. It matches
+// ErrIdRequired or ErrEddRequired with errors.Is, depending on the requirement.
+type CddError struct {
+	Requirement CddRequirement
+	Window      time.Duration   // The rolling window whose total exceeded the threshold, or zero for the single trade
+	Total       decimal.Decimal // In the local currency, including the refused trade
+	Threshold   decimal.Decimal
+}
+
+func (e *CddError) Error() string {
+	what := "trade"
+	if e.Window > 0 {
+		what = fmt.Sprintf("%d-day total", int(e.Window.Hours()/24))
+	}
+	return fmt.Sprintf("%s: %s of %s exceeds %s", e.requirementErr(), what, e.Total, e.Threshold)
+}
+
+func (e *CddError) Is(target error) bool {
+	return target == e.requirementErr()
+}
+
+func (e *CddError) requirementErr() error {
+	if e.Requirement == CddEdd {
+		return ErrEddRequired
+	}
+	return ErrIdRequired
+}
+
+// CheckCdd consults the CDD policy for a customer about to trade localAmount of the local currency. It returns an error
+// wrapping ErrCustomerBlocked if the customer is blocked, a *SanctionsMatchError if they have unresolved potential
+// sanctions matches, or a *CddError naming the first requirement the customer has not met. The policy is chosen by the
+// customer's RiskRating; see RiskPolicy.Cdd. Trades are booked through it, but it can also be called beforehand to tell
+// the teller what to ask for.
+func (c *Core) CheckCdd(ctx context.Context, customerId uuid.UUID, localAmount decimal.Decimal) error {
+	cust, err := c.checkCustomerCleared(ctx, customerId)
+	if err != nil {
+		return err
+	}
+	_, err = c.checkCddThresholds(ctx, c.pgc, cust, localAmount)
+	return err
+}
+
+// checkCustomerCleared gets a customer, returning an error if they are blocked or have unresolved sanctions matches.
+func (c *Core) checkCustomerCleared(ctx context.Context, customerId uuid.UUID) (*Customer, error) {
+	cust, err := c.GetCustomerById(ctx, customerId)
+	if err != nil {
+		return nil, err
+	}
+	if cust.IsBlocked {
+		if cust.BlockedReason == "" {
+			return nil, ErrCustomerBlocked
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCustomerBlocked, cust.BlockedReason)
+	}
+	if err := c.checkSanctions(ctx, cust); err != nil {
+		return nil, err
+	}
+	return cust, nil
+}
+
+// checkCddThresholds returns a *CddError naming the first ID or EDD requirement a customer about to trade localAmount has
+// not met under the CDD policy for their risk rating. bookTrade runs it in the booking's transaction, with the customer
+// locked, so concurrent trades can't each pass a rolling threshold they exceed together.
+//
+// The rolling totals are returned for assessCustomerRisk, and include riskVolumeWindow if the risk policy rates volume.
+func (c *Core) checkCddThresholds(
+	ctx context.Context,
+	db pgQuerier,
+	cust *Customer,
+	localAmount decimal.Decimal,
+) (map[time.Duration]decimal.Decimal, error) {
+	policy := c.cddPolicy(cust.RiskRating)
+	var windows []time.Duration
+	if c.options.Risk.ratesVolume() {
+		windows = append(windows, riskVolumeWindow)
+	}
+	for _, t := range []CddThresholds{policy.Id, policy.Edd} {
+		for _, w := range t.windows() {
+			if w.window > 0 && w.threshold.IsPositive() && !slices.Contains(windows, w.window) {
+				windows = append(windows, w.window)
+			}
+		}
+	}
+	totals, err := c.rollingTotals(ctx, db, cust.Id, localAmount, windows...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, req := range []struct {
+		requirement CddRequirement
+		thresholds  CddThresholds
+		met         func(context.Context, uuid.UUID) (bool, error)
+	}{
+		{CddId, policy.Id, c.hasValidId},
+		{CddEdd, policy.Edd, c.hasCurrentEdd},
+	} {
+		for _, w := range req.thresholds.windows() {
+			if !w.threshold.IsPositive() || !totals[w.window].GreaterThan(w.threshold) {
+				continue
+			}
+
+			met, err := req.met(ctx, cust.Id)
+			if err != nil {
+				return nil, err
+			}
+			if !met {
+				return nil, &CddError{
+					Requirement: req.requirement,
+					Window:      w.window,
+					Total:       totals[w.window],
+					Threshold:   w.threshold,
+				}
+			}
+			break
+		}
+	}
+
+	return totals, nil
+}
+
+// pgQuerier is satisfied by both the pool and a transaction, for work that may need to see a transaction's locks or be
+// rolled back with it. Begin on a transaction starts a savepoint.
+type pgQuerier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// rollingTotals sums the local currency value of a customer's trades over each rolling window, including a new trade of
+// localAmount, in one query over fx_trades.local_amount. The total for a zero window is localAmount alone.
+//
+// PG's fx_trades.state is the source of truth for which trades count: PENDING and POSTED ones. Posts, voids and
+// reversals record their outcome there as they resolve the TB legs, but TB expires pending transfers on its own, so
+// PENDING trades past their expires_at are left out too, whether or not ExpireTrades has caught up with them.
+func (c *Core) rollingTotals(
+	ctx context.Context,
+	db pgQuerier,
+	customerId uuid.UUID,
+	localAmount decimal.Decimal,
+	windows ...time.Duration,
+) (map[time.Duration]decimal.Decimal, error) {
+	totals := map[time.Duration]decimal.Decimal{0: localAmount}
+	if len(windows) == 0 {
+		return totals, nil
+	}
+
+	now := time.Now()
+	since := make([]time.Time, len(windows))
+	for i, window := range windows {
+		since[i] = now.Add(-window)
+	}
+	rows, err := db.Query(
+		ctx,
+		"SELECT w.i, COALESCE(SUM(t.local_amount), 0)::BIGINT FROM unnest($2::TIMESTAMPTZ[]) WITH ORDINALITY AS w(since, i) LEFT JOIN fx_trades t ON t.customer_id = $1 AND (t.state = $4 OR (t.state = $3 AND (t.expires_at IS NULL OR t.expires_at >= NOW()))) AND t.created_at >= w.since GROUP BY w.i",
+		customerId,
+		since,
+		TradePending,
+		TradePosted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var i int
+	var total uint64
+	if _, err := pgx.ForEachRow(rows, []any{&i, &total}, func() error {
+		totals[windows[i-1]] = localAmount.Add(fromMinorUnits(total, c.options.LocalCurrencyLedger))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// tradeLocalValue gets the local currency value of a trade before fees, i.e. its quote's LocalAmount, from its legs. CROSS
+// trades have no local leg, so the currency handed over is converted at the trade's BUY rate.
+func (c *Core) tradeLocalValue(t *FxTrade) decimal.Decimal {
+	localLedger := c.options.LocalCurrencyLedger
+	switch t.Direction {
+	case TradeBuy:
+		return fromMinorUnits(t.DebitAmount+t.Fee, localLedger)
+	case TradeSell:
+		return fromMinorUnits(t.CreditAmount-t.Fee, localLedger)
+	default:
+		return c.localFromForeignAtRate(TradeBuy, fromMinorUnits(t.CreditAmount, t.CreditLedger), t.ExchangeRate)
+	}
+}
+
+// backfillTradeLocalAmounts fills in fx_trades.local_amount for trades booked before it was stored, a batch at a time,
+// from their legs. It is called by New after backfillTradeLegs.
+func (c *Core) backfillTradeLocalAmounts(ctx context.Context) error {
+	for {
+		rows, err := c.pgc.Query(ctx, sqlSelectTrades+" WHERE local_amount IS NULL LIMIT $1", MaxTradesPage)
+		if err != nil {
+			return err
+		}
+		trades, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (FxTrade, error) {
+			trade, err := scanTrade(row)
+			if err != nil {
+				return FxTrade{}, err
+			}
+			return *trade, nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(trades) == 0 {
+			return nil
+		}
+		if err := c.hydrateTrades(trades); err != nil {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(trades))
+		amounts := make([]int64, len(trades))
+		for i := range trades {
+			amount, err := toMinorUnits(c.tradeLocalValue(&trades[i]), c.options.LocalCurrencyLedger)
+			if err != nil {
+				return err
+			}
+			ids[i], amounts[i] = tbToUuid(trades[i].TbPendingId), int64(amount)
+		}
+		if _, err := c.pgc.Exec(
+			ctx,
+			"UPDATE fx_trades t SET local_amount = u.local_amount FROM unnest($1::UUID[], $2::BIGINT[]) AS u(id, local_amount) WHERE t.tb_pending_id = u.id",
+			ids,
+			amounts,
+		); err != nil {
+			return err
+		}
+		c.Logger.Info("backfilled trade local amounts", "trades", len(trades))
+	}
+}
+
+// EddReview records an operator's enhanced due diligence on a customer, e.g. evidence of where their money came from.
+type EddReview struct {
+	Id            uuid.UUID
+	CustomerId    uuid.UUID
+	OperatorId    uuid.UUID
+	SourceOfFunds string
+	Notes         string
+	ReviewedAt    time.Time
+	ExpiresAt     time.Time
+}
+
+type RecordEddReviewData struct {
+	CustomerId    uuid.UUID
+	OperatorId    uuid.UUID
+	SourceOfFunds string
+	Notes         string
+}
+
+// RecordEddReview records an enhanced due diligence review of a customer, which lasts for the EddValidity of the CDD
+// policy for their risk rating. The customer's risk is reassessed, moving their next review date on.
+func (c *Core) RecordEddReview(ctx context.Context, data RecordEddReviewData) (*EddReview, error) {
+	if data.SourceOfFunds == "" {
+		return nil, ErrInvalidEdd
+	}
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	cust, err := c.GetCustomerById(ctx, data.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	review := &EddReview{
+		Id:            id,
+		CustomerId:    data.CustomerId,
+		OperatorId:    op.Id,
+		SourceOfFunds: data.SourceOfFunds,
+		Notes:         data.Notes,
+		ExpiresAt:     time.Now().Add(c.cddPolicy(cust.RiskRating).EddValidity),
+	}
+	if err := c.pgc.QueryRow(
+		ctx,
+		"INSERT INTO customer_edd_reviews (id, customer_id, operator_id, source_of_funds, notes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING reviewed_at",
+		review.Id,
+		review.CustomerId,
+		review.OperatorId,
+		review.SourceOfFunds,
+		review.Notes,
+		review.ExpiresAt,
+	).Scan(&review.ReviewedAt); err != nil {
+		return nil, err
+	}
+
+	c.Logger.Info("edd review recorded", "customer_id", review.CustomerId, "operator_id", review.OperatorId)
+	if _, err := c.AssessCustomerRisk(ctx, review.CustomerId); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// ListEddReviews gets a customer's EDD reviews, newest first.
+func (c *Core) ListEddReviews(ctx context.Context, customerId uuid.UUID) ([]EddReview, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, customer_id, operator_id, source_of_funds, notes, reviewed_at, expires_at FROM customer_edd_reviews WHERE customer_id = $1 ORDER BY reviewed_at DESC",
+		customerId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (EddReview, error) {
+		var r EddReview
+		err := row.Scan(&r.Id, &r.CustomerId, &r.OperatorId, &r.SourceOfFunds, &r.Notes, &r.ReviewedAt, &r.ExpiresAt)
+		return r, err
+	})
+}
+
+// hasCurrentEdd reports whether a customer has an EDD review that has not yet expired.
+func (c *Core) hasCurrentEdd(ctx context.Context, customerId uuid.UUID) (bool, error) {
+	var ok bool
+	err := c.pgc.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM customer_edd_reviews WHERE customer_id = $1 AND expires_at > NOW())",
+		customerId,
+	).Scan(&ok)
+	return ok, err
+}
@@ -11,6 +11,63 @@ type TradeDirection string
 const (
 	TradeBuy  TradeDirection = "BUY"
 	TradeSell TradeDirection = "SELL"
+	// TradeCross exchanges one foreign currency for another. It is priced as a BUY of the currency handed over followed by a
+	// SELL of the currency paid out, triangulating through the local currency.
+	TradeCross TradeDirection = "CROSS"
+)
+
+// MarginKind represents how a Margin's value is applied to the mid rate.
+type MarginKind string
+
+const (
+	MarginBasisPoints MarginKind = "BPS"      // Value is in hundredths of a percent of the mid rate
+	MarginAbsolute    MarginKind = "ABSOLUTE" // Value is added to or subtracted from the mid rate as-is
+)
+
+// TradeState represents where a trade is in its two-phase lifecycle in TB.
+// Trades are booked as PENDING and then either posted once cash has changed hands, voided, or left to expire.
+// A posted trade can later be REVERSED by compensating transfers.
+type TradeState string
+
+const (
+	TradePending  TradeState = "PENDING"
+	TradePosted   TradeState = "POSTED"
+	TradeVoided   TradeState = "VOIDED"
+	TradeExpired  TradeState = "EXPIRED"
+	TradeReversed TradeState = "REVERSED"
+)
+
+// AdjustmentType represents whether a ledger adjustment records more cash (OVER) or less cash (SHORT) than TB expected.
+type AdjustmentType string
+
+const (
+	AdjustmentOver  AdjustmentType = "OVER"
+	AdjustmentShort AdjustmentType = "SHORT"
+)
+
+// StockTransferState represents where a stock transfer is between dispatch and receipt.
+type StockTransferState string
+
+const (
+	StockInTransit StockTransferState = "IN_TRANSIT"
+	StockReceived  StockTransferState = "RECEIVED"
+	StockVoided    StockTransferState = "VOIDED"
+)
+
+// StockBreachKind represents which threshold a branch's stock of a currency has crossed.
+type StockBreachKind string
+
+const (
+	StockBelowMin StockBreachKind = "BELOW_MIN"
+	StockAboveMax StockBreachKind = "ABOVE_MAX"
+)
+
+// FloatDirection represents whether float is moved from the safe into a till or back again.
+type FloatDirection string
+
+const (
+	FloatToTill FloatDirection = "TO_TILL"
+	FloatToSafe FloatDirection = "TO_SAFE"
 )
 
 // AccountCode represents a valid TB Account.code field (uint16).
@@ -19,13 +76,86 @@ type AccountCode uint16
 const (
 	AccountCodeBranchLiquidity AccountCode = 1000
 	AccountCodeBranchFees      AccountCode = 1001
+	AccountCodeBranchInTransit AccountCode = 1002 // Stock dispatched to or from the branch and not yet received
 	AccountCodeBranchOvers     AccountCode = 2000
 	AccountCodeBranchShorts    AccountCode = 2001
 	AccountCodeBranchControl   AccountCode = 9000
+	AccountCodeBranchWholesale AccountCode = 9001 // Counterparty for stock bought from or returned to a wholesale supplier
+
+	AccountCodeTillLiquidity AccountCode = 1100
+	AccountCodeTillOvers     AccountCode = 2100
+	AccountCodeTillShorts    AccountCode = 2101
 
 	AccountCodeCustomer AccountCode = 3000
 )
 
+// CustomerField names a customer detail tracked in customer_history.
+type CustomerField string
+
+const (
+	CustomerFieldFullName   CustomerField = "FULL_NAME"
+	CustomerFieldAddress    CustomerField = "ADDRESS"
+	CustomerFieldPostcode   CustomerField = "POSTCODE"
+	CustomerFieldIsPep      CustomerField = "IS_PEP" // Values are "true" or "false"
+	CustomerFieldOccupation CustomerField = "OCCUPATION"
+)
+
+// RiskRating represents how likely a customer is to be laundering money, as assessed by AssessCustomerRisk.
+type RiskRating string
+
+const (
+	RiskLow    RiskRating = "LOW"
+	RiskMedium RiskRating = "MEDIUM"
+	RiskHigh   RiskRating = "HIGH"
+)
+
+// DocumentType represents a kind of identity document accepted as evidence of a customer's identity.
+type DocumentType string
+
+const (
+	DocumentPassport        DocumentType = "PASSPORT"
+	DocumentDrivingLicence  DocumentType = "DRIVING_LICENCE"
+	DocumentNationalId      DocumentType = "NATIONAL_ID"
+	DocumentResidencePermit DocumentType = "RESIDENCE_PERMIT"
+)
+
+// CddRequirement represents something a customer must provide before trading once a CDD threshold is crossed.
+type CddRequirement string
+
+const (
+	CddId  CddRequirement = "ID"  // Valid, unexpired identity documents on file
+	CddEdd CddRequirement = "EDD" // A current enhanced due diligence review, e.g. of the customer's source of funds
+)
+
+// StructuringKind represents the pattern a structuring hit was found by.
+type StructuringKind string
+
+const (
+	StructuringCustomer StructuringKind = "CUSTOMER" // One customer trading just under the threshold, again and again
+	StructuringAddress  StructuringKind = "ADDRESS"  // Customers at one address splitting an amount over the threshold
+)
+
+// ScreeningState represents where a potential sanctions match is in its review by an operator.
+type ScreeningState string
+
+const (
+	ScreeningPending   ScreeningState = "PENDING"
+	ScreeningCleared   ScreeningState = "CLEARED"   // A false positive; the customer can trade
+	ScreeningConfirmed ScreeningState = "CONFIRMED" // A true match; the customer is blocked
+)
+
+// TransferCode represents a valid TB Transfer.code field (uint16). TB requires this to be non-zero.
+type TransferCode uint16
+
+const (
+	TransferCodeTrade      TransferCode = 1
+	TransferCodeFee        TransferCode = 2
+	TransferCodeFloat      TransferCode = 3 // Moving float between the branch control, the safe and the tills
+	TransferCodeAdjustment TransferCode = 4 // Posting counted overs/shorts against the liquidity account
+	TransferCodeStock      TransferCode = 5 // Moving currency stock between branches, or to and from a wholesale supplier
+	TransferCodeReversal   TransferCode = 6 // Compensating a posted trade's legs, including any fee refunded
+)
+
 // Ledger represents a valid currency for the TB Account.ledger field (uint32).
 type Ledger uint32
 
@@ -6,8 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
 
@@ -21,6 +24,8 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 
+	"github.com/shopspring/decimal"
+
 	tb "github.com/tigerbeetle/tigerbeetle-go"
 	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
 )
@@ -38,8 +43,13 @@ type Core struct {
 	pgc       *pgxpool.Pool
 	options   Options
 	namespace uuid.UUID
+	// denominations holds the notes and coins for each ledger; see Core.Denominations. It is not changed after New.
+	denominations map[Ledger][]Denomination
+
+	// branchAccounts caches each branch's system account IDs; see Core.branchIds.
+	branchAccounts   map[uuid.UUID]*knownIds
+	branchAccountsMu sync.Mutex
 
-	ids    *knownIds
 	Logger *slog.Logger
 }
 
@@ -49,16 +59,72 @@ type Options struct {
 
 	PgUrl string
 
-	HfxDir              string
+	HfxDir string
+	// LocalCurrencyLedger is the currency every branch settles in. Branches share it along with rates, margins and fees.
 	LocalCurrencyLedger Ledger
+
+	// TradeTimeout is how long a booked trade stays pending in TB before it expires if it is not posted or voided.
+	// Zero means pending trades never expire. TB timeouts have a resolution of one second, so otherwise it must be between
+	// one second and math.MaxUint32 seconds, and is rounded down to a whole second.
+	TradeTimeout time.Duration
+	// QuoteValidity is how long a quote's rate is locked for. Defaults to DefaultQuoteValidity.
+	QuoteValidity time.Duration
+
+	// RateProvider supplies mid rates for every foreign ledger. Defaults to a PgRateProvider on the core's PG pool.
+	RateProvider RateProvider
+	// Margins holds the buy and sell margins for each foreign ledger. Ledgers without an entry trade at the mid rate.
+	Margins map[Ledger]LedgerMargins
+	// Fees holds the fee schedules for each foreign ledger, charged in the local currency. Ledgers without an entry are free.
+	Fees map[Ledger]LedgerFees
+
+	// IdRequiredAbove is the local currency value of a trade above which the customer must have valid, unexpired ID on file.
+	// Zero means ID is never required for a single trade. It is the default for Cdd.Id.SingleTrade.
+	IdRequiredAbove decimal.Decimal
+	// Cdd sets when customers need ID or enhanced due diligence before they can trade, over single trades and rolling
+	// windows.
+	Cdd CddPolicy
+	// Risk sets how customers' risk is rated, and the CDD policy for each rating.
+	Risk RiskPolicy
+	// Structuring sets what ScanStructuring looks for.
+	Structuring StructuringPolicy
+	// SanctionsMatchScore is the score from 0 to 1 at which a customer is a potential match for a sanctions list entry.
+	// Defaults to DefaultSanctionsMatchScore.
+	SanctionsMatchScore float32
+
+	// ReversalWindow is how long after a trade is booked it can be reversed, e.g. a cooling-off period. Zero means trades
+	// can be reversed at any time.
+	ReversalWindow time.Duration
+	// BuyBackMargins holds the margin taken from the customer when a trade in each foreign ledger is reversed, applied to the
+	// trade's original rate. Trades in ledgers without an entry are reversed at the original rate, with any fee refunded.
+	BuyBackMargins map[Ledger]Margin
+
+	// StockLevels holds the minimum and maximum stock each branch should hold of a ledger. Ledgers without an entry are not
+	// monitored.
+	StockLevels map[Ledger]StockLevel
+	// OnStockBreach, if set, is called whenever a trade pushes a branch's stock across one of its StockLevels. It is called
+	// synchronously while booking the trade, so it should not block.
+	OnStockBreach func(StockBreach)
+	// StockReserves holds an amount of each ledger that a till or safe keeps back when paying out, so trades are refused
+	// before it is emptied. Ledgers without an entry can be paid out down to zero.
+	StockReserves map[Ledger]decimal.Decimal
+
+	// Denominations holds the face values of the notes and coins for each ledger, in display units, e.g. 50 or 0.01. An
+	// entry replaces the ledger's CurrencyDenominations. Ledgers without either can only be counted by total.
+	Denominations map[Ledger][]decimal.Decimal
 }
 
+// knownIds holds one branch's system account IDs.
 type knownIds struct {
+	// generation is the branch's liquidity generation; see Core.RotateLiquidityAccounts.
+	generation int
+
 	// Map from currency code to account ID
 	liquidity map[Ledger]tbTypes.Uint128
 	overs     map[Ledger]tbTypes.Uint128
 	shorts    map[Ledger]tbTypes.Uint128
 	control   map[Ledger]tbTypes.Uint128
+	inTransit map[Ledger]tbTypes.Uint128
+	wholesale map[Ledger]tbTypes.Uint128
 	fees      tbTypes.Uint128 // Only local currency
 }
 
@@ -82,6 +148,41 @@ func New(ctx context.Context, logger *slog.Logger, options Options) (*Core, erro
 		}
 		options.HfxDir = filepath.Join(homeDir, FILENAME_HFX_DIR)
 	}
+	if options.TradeTimeout != 0 &&
+		(options.TradeTimeout < time.Second || options.TradeTimeout/time.Second > math.MaxUint32) {
+		return nil, errors.New("core: TradeTimeout must be zero, or between one second and math.MaxUint32 seconds")
+	}
+	for ledger, level := range options.StockLevels {
+		for _, threshold := range []decimal.Decimal{level.Min, level.Max} {
+			if _, err := toMinorUnits(threshold, ledger); err != nil {
+				return nil, fmt.Errorf("core: invalid StockLevels for ledger %d: %w", ledger, err)
+			}
+		}
+	}
+	for ledger, reserve := range options.StockReserves {
+		if _, err := toMinorUnits(reserve, ledger); err != nil {
+			return nil, fmt.Errorf("core: invalid StockReserves for ledger %d: %w", ledger, err)
+		}
+	}
+	if options.QuoteValidity == 0 {
+		options.QuoteValidity = DefaultQuoteValidity
+	}
+	if options.Cdd.Id.SingleTrade.IsZero() {
+		options.Cdd.Id.SingleTrade = options.IdRequiredAbove
+	}
+	if options.Cdd.EddValidity == 0 {
+		options.Cdd.EddValidity = DefaultEddValidity
+	}
+	options.Risk.setDefaults(options.Cdd)
+	options.Structuring.setDefaults()
+	if options.SanctionsMatchScore == 0 {
+		options.SanctionsMatchScore = DefaultSanctionsMatchScore
+	}
+
+	denominations, err := loadDenominations(options.Denominations)
+	if err != nil {
+		return nil, err
+	}
 
 	namespace, err := loadNamespace(options, logger)
 	if err != nil {
@@ -99,21 +200,43 @@ func New(ctx context.Context, logger *slog.Logger, options Options) (*Core, erro
 		return nil, err
 	}
 
-	ids, err := initSystemAccounts(options, namespace, tbc, logger)
+	var generation int
+	if err := pgc.QueryRow(ctx, "SELECT liquidity_generation FROM branches WHERE id = $1", DefaultBranchId).
+		Scan(&generation); err != nil {
+		tbc.Close()
+		pgc.Close()
+		return nil, fmt.Errorf("core: failed to get default branch: %w", err)
+	}
+
+	ids, err := initSystemAccounts(options, namespace, generation, tbc, logger)
 	if err != nil {
 		tbc.Close()
 		pgc.Close()
 		return nil, err
 	}
 
-	return &Core{
-		tbc:       tbc,
-		pgc:       pgc,
-		options:   options,
-		namespace: namespace,
-		ids:       ids,
-		Logger:    logger,
-	}, nil
+	if options.RateProvider == nil {
+		options.RateProvider = NewPgRateProvider(pgc)
+	}
+
+	c := &Core{
+		tbc:            tbc,
+		pgc:            pgc,
+		options:        options,
+		namespace:      namespace,
+		denominations:  denominations,
+		branchAccounts: map[uuid.UUID]*knownIds{DefaultBranchId: ids},
+		Logger:         logger,
+	}
+	if err := c.backfillTradeLegs(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("core: failed to backfill trade legs: %w", err)
+	}
+	if err := c.backfillTradeLocalAmounts(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("core: failed to backfill trade local amounts: %w", err)
+	}
+	return c, nil
 }
 
 // Close shuts down HyperFX gracefully, including closing DB connections.
@@ -278,23 +401,28 @@ func migratePg(ctx context.Context, pgc *pgxpool.Pool, logger *slog.Logger) erro
 }
 
 // initSystemAccounts gets Tigerbeetle system account IDs and ensures system accounts exist.
+// New passes the root namespace, giving the default branch's accounts; other branches pass their own namespace.
 func initSystemAccounts(
 	options Options,
 	namespace uuid.UUID,
+	generation int,
 	tbc tb.Client,
 	logger *slog.Logger,
 ) (*knownIds, error) {
 	ids := &knownIds{
-		liquidity: map[Ledger]tbTypes.Uint128{},
-		overs:     map[Ledger]tbTypes.Uint128{},
-		shorts:    map[Ledger]tbTypes.Uint128{},
-		control:   map[Ledger]tbTypes.Uint128{},
+		generation: generation,
+		liquidity:  map[Ledger]tbTypes.Uint128{},
+		overs:      map[Ledger]tbTypes.Uint128{},
+		shorts:     map[Ledger]tbTypes.Uint128{},
+		control:    map[Ledger]tbTypes.Uint128{},
+		inTransit:  map[Ledger]tbTypes.Uint128{},
+		wholesale:  map[Ledger]tbTypes.Uint128{},
 	}
 	accountCreationBatch := []tbTypes.Account{}
 
-	// LIQUIDITY, DISCREPANCY AND CONTROL ACCOUNTS
+	// LIQUIDITY, DISCREPANCY, CONTROL AND STOCK ACCOUNTS
 	for currCode := range CurrencyAssetScales {
-		liqKey := fmt.Sprintf("branch_liquidity_%d", currCode)
+		liqKey := liquidityKey(fmt.Sprintf("branch_liquidity_%d", currCode), generation)
 		liqId := idWithNamespace(namespace, liqKey)
 
 		ids.liquidity[currCode] = liqId
@@ -302,9 +430,7 @@ func initSystemAccounts(
 			ID:     liqId,
 			Ledger: uint32(currCode),
 			Code:   uint16(AccountCodeBranchLiquidity),
-			Flags: tbTypes.AccountFlags{
-				History: true,
-			}.ToUint16(),
+			Flags:  liquidityFlags(generation),
 		})
 
 		oversKey := fmt.Sprintf("branch_overs_%d", currCode)
@@ -347,10 +473,37 @@ func initSystemAccounts(
 				History: true,
 			}.ToUint16(),
 		})
+
+		inTransitKey := fmt.Sprintf("branch_in_transit_%d", currCode)
+		inTransitId := idWithNamespace(namespace, inTransitKey)
+
+		ids.inTransit[currCode] = inTransitId
+		accountCreationBatch = append(accountCreationBatch, tbTypes.Account{
+			ID:     inTransitId,
+			Ledger: uint32(currCode),
+			Code:   uint16(AccountCodeBranchInTransit),
+			Flags: tbTypes.AccountFlags{
+				History: true,
+			}.ToUint16(),
+		})
+
+		wholesaleKey := fmt.Sprintf("branch_wholesale_%d", currCode)
+		wholesaleId := idWithNamespace(namespace, wholesaleKey)
+
+		ids.wholesale[currCode] = wholesaleId
+		accountCreationBatch = append(accountCreationBatch, tbTypes.Account{
+			ID:     wholesaleId,
+			Ledger: uint32(currCode),
+			Code:   uint16(AccountCodeBranchWholesale),
+			Flags: tbTypes.AccountFlags{
+				History: true,
+			}.ToUint16(),
+		})
 	}
 
 	// FEES ACCOUNT
 	feesId := idWithNamespace(namespace, "branch_fees")
+	ids.fees = feesId
 	accountCreationBatch = append(accountCreationBatch, tbTypes.Account{
 		ID:     feesId,
 		Ledger: uint32(options.LocalCurrencyLedger),
@@ -422,6 +575,45 @@ func uuidToTb(u uuid.UUID) tbTypes.Uint128 {
 	return tbTypes.BytesToUint128([16]byte(u.Bytes()))
 }
 
-func tbToUuid(i tbTypes.Uint128) (uuid.UUID, error) {
-	return uuid.FromBytes(i[:])
+// tbToUuid converts a tbTypes.Uint128 to a uuid.UUID, e.g. for storing TB IDs in PG.
+func tbToUuid(i tbTypes.Uint128) uuid.UUID {
+	return uuid.UUID(i.Bytes())
+}
+
+// tbAmount converts a TB amount to a uint64. Amounts in HyperFX are always created from uint64s, so this never truncates.
+func tbAmount(i tbTypes.Uint128) uint64 {
+	amount := i.BigInt()
+	return amount.Uint64()
+}
+
+// TransferError is returned when TB rejects a transfer in a CreateTransfers request.
+type TransferError struct {
+	Index  uint32
+	Result tbTypes.CreateTransferResult
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("core: TB rejected transfer %d: %s", e.Index, e.Result)
+}
+
+// createTransfers sends a batch of transfers to TB, returning a *TransferError if any were rejected.
+// In a linked batch, TB reports TransferLinkedEventFailed for every event in the chain, so the result that actually caused the
+// failure is preferred.
+func (c *Core) createTransfers(transfers []tbTypes.Transfer) error {
+	results, err := c.tbc.CreateTransfers(transfers)
+	if err != nil {
+		return fmt.Errorf("core: failed to send create transfers request to TB: %w", err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	failed := results[0]
+	for _, r := range results {
+		if r.Result != tbTypes.TransferLinkedEventFailed {
+			failed = r
+			break
+		}
+	}
+	return &TransferError{Index: failed.Index, Result: failed.Result}
 }
@@ -2,59 +2,178 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
 	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
 )
 
+var ErrEmptySearch = errors.New("core: a name or postcode must be given to search by")
+
 type Customer struct {
 	Id        uuid.UUID
 	FullName  string
 	CreatedAt time.Time
 	Address   string
 	Postcode  string
+	// DateOfBirth and Nationality (ISO 3166-1 alpha-2) are optional, and break ties when screening against sanctions lists.
+	DateOfBirth time.Time
+	Nationality string
+
+	// IsPep marks a politically exposed person. Along with Occupation, it is set with SetCustomerRiskProfile.
+	IsPep      bool
+	Occupation string
+	// RiskRating is kept up to date by AssessCustomerRisk, which runs on every trade.
+	RiskRating RiskRating
+	// NextReviewAt is when the customer is next due an EDD review; see ListCustomersDueReview. Zero if their risk has
+	// never been assessed.
+	NextReviewAt time.Time
 
 	IsBlocked bool
 	// BlockedReason is nullable. Likely want to COALESCE with an empty string.
-	BlockedReason bool
+	BlockedReason string
+
+	// SanctionsMatches holds the potential sanctions matches raised by CreateCustomer. It is not stored, so it is empty
+	// for customers read back later; see ListSanctionsScreenings.
+	SanctionsMatches []SanctionsScreening
 }
 
 type CreateCustomerData struct {
-	FullName string
-	Address  string
-	Postcode string
+	FullName    string
+	Address     string
+	Postcode    string
+	DateOfBirth time.Time // Optional
+	Nationality string    // Optional
+	IsPep       bool
+	Occupation  string // Optional
 }
 
 // CreateCustomer inserts a customer into the PG database. It does not create a TB account.
 // TB accounts are created automatically when a transaction is made.
+//
+// The customer's risk is assessed, then they are screened against the current sanctions lists, in the same PG
+// transaction as the insert, so if either fails no customer is created. If there are potential matches, the customer
+// is still created but blocked, with the matches in SanctionsMatches, and stays blocked until an operator clears every
+// match with ResolveSanctionsScreening.
 func (c *Core) CreateCustomer(ctx context.Context, data CreateCustomerData) (*Customer, error) {
 	id, err := uuid.NewV7()
 	if err != nil {
 		return nil, err
 	}
 
+	var dateOfBirth *time.Time
+	if !data.DateOfBirth.IsZero() {
+		dateOfBirth = &data.DateOfBirth
+	}
+	var nationality *string
+	if data.Nationality != "" {
+		n := strings.ToUpper(data.Nationality)
+		nationality = &n
+	}
+
+	var occupation *string
+	if data.Occupation != "" {
+		occupation = &data.Occupation
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	var createdAt time.Time
-	if err := c.pgc.QueryRow(ctx, "INSERT INTO customers (id, full_name, address, postcode) VALUES ($1, $2, $3, $4) RETURNING created_at", id, data.FullName, data.Address, data.Postcode).
+	if err := tx.QueryRow(ctx, "INSERT INTO customers (id, full_name, address, postcode, date_of_birth, nationality, is_pep, occupation) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at", id, data.FullName, data.Address, data.Postcode, dateOfBirth, nationality, data.IsPep, occupation).
 		Scan(&createdAt); err != nil {
 		return nil, err
 	}
 
-	return &Customer{
-		Id:        id,
-		FullName:  data.FullName,
-		CreatedAt: createdAt,
-		Address:   data.Address,
-		Postcode:  data.Postcode,
-	}, nil
+	cust := &Customer{
+		Id:          id,
+		FullName:    data.FullName,
+		CreatedAt:   createdAt,
+		Address:     data.Address,
+		Postcode:    data.Postcode,
+		DateOfBirth: data.DateOfBirth,
+		IsPep:       data.IsPep,
+		Occupation:  data.Occupation,
+	}
+	if nationality != nil {
+		cust.Nationality = *nationality
+	}
+
+	assessment, err := c.assessCustomerRisk(ctx, tx, cust, nil)
+	if err != nil {
+		return nil, err
+	}
+	cust.RiskRating = assessment.Rating
+	cust.NextReviewAt = assessment.NextReviewAt
+
+	matches, err := c.screenCustomer(ctx, tx, cust)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 {
+		if _, err := tx.Exec(
+			ctx,
+			"UPDATE customers SET is_blocked = TRUE, blocked_reason = $1 WHERE id = $2",
+			sanctionsPendingReason,
+			id,
+		); err != nil {
+			return nil, err
+		}
+		cust.IsBlocked = true
+		cust.BlockedReason = sanctionsPendingReason
+		cust.SanctionsMatches = matches
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return cust, nil
 }
 
 func (c *Core) GetCustomerById(ctx context.Context, id uuid.UUID) (*Customer, error) {
+	return scanCustomer(c.pgc.QueryRow(ctx, "SELECT "+sqlCustomerColumns+" FROM customers c WHERE c.id = $1", id))
+}
+
+// sqlCustomerColumns selects a customer from "customers c" for scanCustomer.
+const sqlCustomerColumns = "c.id, c.full_name, c.created_at, c.address, c.postcode, c.date_of_birth, COALESCE(c.nationality, ''), c.is_pep, COALESCE(c.occupation, ''), c.risk_rating, c.next_review_at, c.is_blocked, COALESCE(c.blocked_reason, '')"
+
+// scanCustomer scans a row starting with sqlCustomerColumns, followed by any extra columns into dest.
+func scanCustomer(row pgx.Row, dest ...any) (*Customer, error) {
 	cust := &Customer{}
-	err := c.pgc.QueryRow(ctx, "SELECT id, full_name, created_at, address, postcode, is_blocked, COALESCE(blocked_reason, '') FROM customers WHERE id = $1", id).
-		Scan(&cust.Id, &cust.FullName, &cust.CreatedAt, &cust.Address, &cust.Postcode, &cust.IsBlocked, &cust.BlockedReason)
+	var dateOfBirth, nextReviewAt *time.Time
+	if err := row.Scan(append([]any{
+		&cust.Id,
+		&cust.FullName,
+		&cust.CreatedAt,
+		&cust.Address,
+		&cust.Postcode,
+		&dateOfBirth,
+		&cust.Nationality,
+		&cust.IsPep,
+		&cust.Occupation,
+		&cust.RiskRating,
+		&nextReviewAt,
+		&cust.IsBlocked,
+		&cust.BlockedReason,
+	}, dest...)...); err != nil {
+		return nil, err
+	}
 
-	return cust, err
+	if dateOfBirth != nil {
+		cust.DateOfBirth = *dateOfBirth
+	}
+	if nextReviewAt != nil {
+		cust.NextReviewAt = *nextReviewAt
+	}
+	return cust, nil
 }
 
 // UpdateCustomerBlocked sets a customer's is_blocked flag and blocked_reason.
@@ -72,6 +191,176 @@ func (c *Core) UpdateCustomerBlocked(
 	return err
 }
 
+type UpdateCustomerData struct {
+	OperatorId uuid.UUID
+	// Fields left empty are not changed.
+	FullName string
+	Address  string
+	Postcode string
+}
+
+// CustomerChange records one of a customer's details being changed by an operator.
+type CustomerChange struct {
+	Id         uuid.UUID
+	CustomerId uuid.UUID
+	Field      CustomerField
+	OldValue   string
+	NewValue   string
+	OperatorId uuid.UUID
+	ChangedAt  time.Time
+}
+
+// UpdateCustomer changes a customer's name, address and/or postcode, writing each changed field to customer_history.
+func (c *Core) UpdateCustomer(ctx context.Context, id uuid.UUID, data UpdateCustomerData) (*Customer, error) {
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cust, err := scanCustomer(tx.QueryRow(ctx, "SELECT "+sqlCustomerColumns+" FROM customers c WHERE c.id = $1 FOR UPDATE", id))
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []CustomerChange{}
+	for _, f := range []struct {
+		field    CustomerField
+		current  *string
+		newValue string
+	}{
+		{CustomerFieldFullName, &cust.FullName, data.FullName},
+		{CustomerFieldAddress, &cust.Address, data.Address},
+		{CustomerFieldPostcode, &cust.Postcode, data.Postcode},
+	} {
+		if f.newValue == "" || f.newValue == *f.current {
+			continue
+		}
+		changeId, err := uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, CustomerChange{
+			Id:         changeId,
+			CustomerId: id,
+			Field:      f.field,
+			OldValue:   *f.current,
+			NewValue:   f.newValue,
+			OperatorId: op.Id,
+		})
+		*f.current = f.newValue
+	}
+	if len(changes) == 0 {
+		return cust, nil
+	}
+
+	// A new name must be screened against the sanctions lists again before the customer next trades.
+	if _, err := tx.Exec(
+		ctx,
+		"UPDATE customers SET full_name = $1, address = $2, postcode = $3, sanctions_screened_at = CASE WHEN full_name = $1 THEN sanctions_screened_at END WHERE id = $4",
+		cust.FullName,
+		cust.Address,
+		cust.Postcode,
+		id,
+	); err != nil {
+		return nil, err
+	}
+	for i := range changes {
+		if err := tx.QueryRow(
+			ctx,
+			"INSERT INTO customer_history (id, customer_id, field, old_value, new_value, operator_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING changed_at",
+			changes[i].Id,
+			changes[i].CustomerId,
+			changes[i].Field,
+			changes[i].OldValue,
+			changes[i].NewValue,
+			changes[i].OperatorId,
+		).Scan(&changes[i].ChangedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	c.Logger.Info("customer updated", "customer_id", id, "operator_id", op.Id, "changes", len(changes))
+	return cust, nil
+}
+
+// GetCustomerHistory gets every change made to a customer's details, oldest first.
+func (c *Core) GetCustomerHistory(ctx context.Context, id uuid.UUID) ([]CustomerChange, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, customer_id, field, old_value, new_value, operator_id, changed_at FROM customer_history WHERE customer_id = $1 ORDER BY changed_at, id",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (CustomerChange, error) {
+		var ch CustomerChange
+		err := row.Scan(&ch.Id, &ch.CustomerId, &ch.Field, &ch.OldValue, &ch.NewValue, &ch.OperatorId, &ch.ChangedAt)
+		return ch, err
+	})
+}
+
+// GetCustomerAsOf gets the details held for a customer at a point in time, e.g. when a trade was booked, by undoing every
+// change made since. The blocked status and risk rating are always the current ones.
+func (c *Core) GetCustomerAsOf(ctx context.Context, id uuid.UUID, at time.Time) (*Customer, error) {
+	cust, err := c.GetCustomerById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if at.Before(cust.CreatedAt) {
+		return nil, pgx.ErrNoRows
+	}
+
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT field, old_value FROM customer_history WHERE customer_id = $1 AND changed_at > $2 ORDER BY changed_at DESC, id DESC",
+		id,
+		at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var field CustomerField
+		var oldValue string
+		if err := rows.Scan(&field, &oldValue); err != nil {
+			return nil, err
+		}
+		switch field {
+		case CustomerFieldFullName:
+			cust.FullName = oldValue
+		case CustomerFieldAddress:
+			cust.Address = oldValue
+		case CustomerFieldPostcode:
+			cust.Postcode = oldValue
+		case CustomerFieldIsPep:
+			cust.IsPep = oldValue == "true"
+		case CustomerFieldOccupation:
+			cust.Occupation = oldValue
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cust, nil
+}
+
 type CustomerLedgerAccount struct {
 	CustomerId  uuid.UUID
 	Ledger      uint32
@@ -80,18 +369,12 @@ type CustomerLedgerAccount struct {
 
 // AddCustomerLedgerAccount stores a customer's TB account ID for a certain ledger in PG.
 func (c *Core) AddCustomerLedgerAccount(ctx context.Context, data CustomerLedgerAccount) error {
-	// TODO: maybe add a helper function to make this look less awful
-	tbAccountUuid, err := tbToUuid(data.TbAccountId)
-	if err != nil {
-		return err
-	}
-
-	_, err = c.pgc.Exec(
+	_, err := c.pgc.Exec(
 		ctx,
 		"INSERT INTO customer_ledger_accounts (customer_id, ledger_id, tb_account_id) VALUES ($1, $2, $3)",
 		data.CustomerId,
 		data.Ledger,
-		tbAccountUuid,
+		tbToUuid(data.TbAccountId),
 	)
 	return err
 }
@@ -114,3 +397,147 @@ func (c *Core) GetCustomerLedgerAccount(
 
 	return uuidToTb(tbAccountUuid), nil
 }
+
+// customerLedgerAccount gets a customer's TB account ID for a ledger, creating the TB account and storing it in PG if the
+// customer has not traded in that currency before.
+func (c *Core) customerLedgerAccount(
+	ctx context.Context,
+	customerId uuid.UUID,
+	ledger Ledger,
+) (tbTypes.Uint128, error) {
+	id, err := c.GetCustomerLedgerAccount(ctx, customerId, uint32(ledger))
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return tbTypes.Uint128{}, err
+	}
+
+	account := tbTypes.Account{
+		ID:          tbTypes.ID(),
+		UserData128: uuidToTb(customerId),
+		Ledger:      uint32(ledger),
+		Code:        uint16(AccountCodeCustomer),
+		Flags: tbTypes.AccountFlags{
+			History: true,
+		}.ToUint16(),
+	}
+	accountErrors, err := c.tbc.CreateAccounts([]tbTypes.Account{account})
+	if err != nil {
+		return tbTypes.Uint128{}, fmt.Errorf("core: failed to send create accounts request to TB: %w", err)
+	}
+	for _, e := range accountErrors {
+		if e.Result != tbTypes.AccountOK {
+			return tbTypes.Uint128{}, fmt.Errorf("core: failed to create customer account: %s", e.Result)
+		}
+	}
+
+	if err := c.AddCustomerLedgerAccount(ctx, CustomerLedgerAccount{
+		CustomerId:  customerId,
+		Ledger:      uint32(ledger),
+		TbAccountId: account.ID,
+	}); err != nil {
+		// NOTE: if another trade created the account concurrently, the TB account we just made is left unused.
+		if id, lookupErr := c.GetCustomerLedgerAccount(ctx, customerId, uint32(ledger)); lookupErr == nil {
+			return id, nil
+		}
+		return tbTypes.Uint128{}, err
+	}
+
+	c.Logger.Debug(
+		"created customer ledger account",
+		"customer_id",
+		customerId,
+		"ledger",
+		ledger,
+		"tb_account_id",
+		account.ID,
+	)
+	return account.ID, nil
+}
+
+// MaxCustomersPage is the most customers SearchCustomers returns at once.
+const MaxCustomersPage = 100
+
+// CustomerSearch is what a teller knows about a returning customer. Either field can be left empty.
+type CustomerSearch struct {
+	// Name matches the start of the full name or of any word in it, e.g. a surname, or any name similar enough to it to
+	// catch typos.
+	Name string
+	// Postcode matches the start of a customer's postcode, ignoring case and whitespace, e.g. "sw1a" or "SW1A 1AA".
+	Postcode string
+}
+
+// CustomerMatch is a customer found by SearchCustomers.
+type CustomerMatch struct {
+	Customer
+	LastTradeAt time.Time // Zero if the customer has never traded
+	// Similarity is the trigram similarity of the customer's name to the one searched for, from 0 to 1.
+	Similarity float32
+}
+
+// NormalisePostcode upper-cases a postcode and strips its whitespace, matching customers.postcode_normalised.
+func NormalisePostcode(postcode string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, postcode)
+}
+
+// SearchCustomers finds customers by name and/or postcode, most recently active first, then by how closely their name
+// matches. Customers who have never traded count as active when they were created. Results are paged by offset, with limit
+// capped at MaxCustomersPage.
+func (c *Core) SearchCustomers(
+	ctx context.Context,
+	search CustomerSearch,
+	offset int,
+	limit int,
+) ([]CustomerMatch, error) {
+	name := strings.ToLower(strings.Join(strings.Fields(search.Name), " "))
+	postcode := NormalisePostcode(search.Postcode)
+	if name == "" && postcode == "" {
+		return nil, ErrEmptySearch
+	}
+	if limit <= 0 || limit > MaxCustomersPage {
+		limit = MaxCustomersPage
+	}
+	offset = max(offset, 0)
+
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT "+sqlCustomerColumns+", a.last_trade_at, CASE WHEN $1 = '' THEN 0 ELSE similarity(lower(c.full_name), $1) END AS score FROM customers c LEFT JOIN LATERAL (SELECT MAX(created_at) AS last_trade_at FROM fx_trades WHERE customer_id = c.id) a ON TRUE WHERE ($1 = '' OR lower(c.full_name) LIKE $2 || '%' OR ' ' || lower(c.full_name) LIKE '% ' || $2 || '%' OR lower(c.full_name) % $1) AND ($3 = '' OR c.postcode_normalised LIKE $4 || '%') ORDER BY COALESCE(a.last_trade_at, c.created_at) DESC, score DESC, c.id OFFSET $5 LIMIT $6",
+		name,
+		escapeLike(name),
+		postcode,
+		escapeLike(postcode),
+		offset,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (CustomerMatch, error) {
+		var m CustomerMatch
+		var lastTradeAt *time.Time
+		cust, err := scanCustomer(row, &lastTradeAt, &m.Similarity)
+		if err != nil {
+			return CustomerMatch{}, err
+		}
+		m.Customer = *cust
+		if lastTradeAt != nil {
+			m.LastTradeAt = *lastTradeAt
+		}
+		return m, nil
+	})
+}
+
+// likeEscaper escapes the characters LIKE treats specially, with PG's default escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes a string to be matched literally in a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,24 @@
+package core
+
+import "testing"
+
+func TestNormalisePostcode(t *testing.T) {
+	tests := []struct {
+		postcode string
+		want     string
+	}{
+		{postcode: "SW1A 1AA", want: "SW1A1AA"},
+		{postcode: "sw1a 1aa", want: "SW1A1AA"},
+		{postcode: "  Sw1A\t1aA \n", want: "SW1A1AA"},
+		{postcode: "EC1A  1BB", want: "EC1A1BB"},
+		{postcode: "sw1a", want: "SW1A"},
+		{postcode: "", want: ""},
+		{postcode: "   ", want: ""},
+		{postcode: "d02 x285", want: "D02X285"},
+	}
+	for _, tt := range tests {
+		if got := NormalisePostcode(tt.postcode); got != tt.want {
+			t.Errorf("NormalisePostcode(%q) = %q, want %q", tt.postcode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrLedgerNotOpen        = errors.New("core: ledger has not been opened, or has been closed")
+	ErrLedgerAlreadyOpen    = errors.New("core: ledger is already open")
+	ErrPendingTransfers     = errors.New("core: ledger has pending transfers; post or void them first")
+	ErrLedgerNotSettled     = errors.New("core: ledger's liquidity account still holds cash from a day that was not closed")
+	ErrPreviousDayNotClosed = errors.New("core: ledger was opened on a previous day that has not been closed")
+)
+
+// sqlLedgerOpen checks whether the latest opening of ledger $2 in branch $1 has not been closed since. A day stays open
+// past midnight until it is closed, but can then only be counted and closed; see checkLedgersTrading.
+const sqlLedgerOpen = `SELECT EXISTS (
+	SELECT 1 FROM (
+		SELECT opened_at FROM sod_openings
+		WHERE branch_id = $1 AND ledger_id = $2
+		ORDER BY opened_at DESC LIMIT 1
+	) s
+	WHERE NOT EXISTS (
+		SELECT 1 FROM eod_closings e
+		WHERE e.branch_id = $1 AND e.ledger_id = $2 AND e.closed_at >= s.opened_at
+	)
+)`
+
+// sqlLedgerOpenedToday checks whether the latest opening of ledger $2 in branch $1 was today, in PG's time zone.
+const sqlLedgerOpenedToday = "SELECT opened_at >= date_trunc('day', NOW()) FROM sod_openings WHERE branch_id = $1 AND ledger_id = $2 ORDER BY opened_at DESC LIMIT 1"
+
+// lockBranchDay serialises OpenDay, CloseDay and CashUpTill within a branch until tx ends, and waits for trades and float moves
+// holding shareBranchDay to finish. FOR NO KEY UPDATE doesn't conflict with the locks taken by inserts referencing the
+// branch, so other work at the branch carries on meanwhile.
+func lockBranchDay(ctx context.Context, tx pgx.Tx, branchId uuid.UUID) error {
+	_, err := tx.Exec(ctx, "SELECT 1 FROM branches WHERE id = $1 FOR NO KEY UPDATE", branchId)
+	return err
+}
+
+// shareBranchDay keeps lockBranchDay out of a branch until tx ends, for work that moves its cash. Holders don't block each
+// other.
+func shareBranchDay(ctx context.Context, tx pgx.Tx, branchId uuid.UUID) error {
+	_, err := tx.Exec(ctx, "SELECT 1 FROM branches WHERE id = $1 FOR SHARE", branchId)
+	return err
+}
+
+// SodOpening records the float a ledger was opened with at the start of the day.
+type SodOpening struct {
+	TbPendingId tbTypes.Uint128
+	BranchId    uuid.UUID
+	Ledger      Ledger
+	OperatorId  uuid.UUID
+	Amount      uint64 // In the ledger's minor unit
+	OpenedAt    time.Time
+	// Denominations is the counted breakdown by face value, or nil if the float was counted by total.
+	Denominations map[Denomination]uint64
+}
+
+// OpenDay opens each ledger in counts for trading in the operator's branch, with the counted float.
+// The float is moved from the branch control account into the liquidity account, as one linked batch of pending transfers
+// that is posted once the openings are recorded in PG. A ledger can't be opened again until it has been closed, and its
+// liquidity account must be empty apart from stock in transit, as CloseDay leaves it. A ledger left open from a previous
+// day returns ErrPreviousDayNotClosed, here and when trading.
+func (c *Core) OpenDay(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	counts map[Ledger]CashCount,
+) ([]SodOpening, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if len(counts) == 0 {
+		return nil, ErrInvalidAmount
+	}
+	if err := c.checkBranchActive(ctx, op.BranchId); err != nil {
+		return nil, err
+	}
+	ids, err := c.branchIds(ctx, op.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	openings := make([]SodOpening, 0, len(counts))
+	transfers := make([]tbTypes.Transfer, 0, len(counts))
+	for ledger, count := range counts {
+		amount, err := count.minorUnits(ledger, c.denominations[ledger])
+		if err != nil {
+			return nil, err
+		}
+		if amount == 0 {
+			return nil, ErrInvalidAmount
+		}
+
+		transfer := tbTypes.Transfer{
+			ID:              tbTypes.ID(),
+			DebitAccountID:  ids.liquidity[ledger],
+			CreditAccountID: ids.control[ledger],
+			Amount:          tbTypes.ToUint128(amount),
+			Ledger:          uint32(ledger),
+			Code:            uint16(TransferCodeFloat),
+			Flags: tbTypes.TransferFlags{
+				Linked:  len(transfers) < len(counts)-1,
+				Pending: true,
+			}.ToUint16(),
+		}
+		transfers = append(transfers, transfer)
+		openings = append(openings, SodOpening{
+			TbPendingId:   transfer.ID,
+			BranchId:      op.BranchId,
+			Ledger:        ledger,
+			OperatorId:    operatorId,
+			Amount:        amount,
+			Denominations: count.Denominations,
+		})
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockBranchDay(ctx, tx, op.BranchId); err != nil {
+		return nil, err
+	}
+	liquidityIds := make([]tbTypes.Uint128, len(openings))
+	for i, o := range openings {
+		liquidityIds[i] = ids.liquidity[o.Ledger]
+	}
+	accounts, err := c.tbc.LookupAccounts(liquidityIds)
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+	}
+	if len(accounts) != len(liquidityIds) {
+		return nil, errors.New("core: liquidity accounts not found in TB")
+	}
+
+	for i := range openings {
+		o := &openings[i]
+		var alreadyOpen bool
+		if err := tx.QueryRow(ctx, sqlLedgerOpen, o.BranchId, o.Ledger).Scan(&alreadyOpen); err != nil {
+			return nil, err
+		}
+		if alreadyOpen {
+			var today bool
+			if err := tx.QueryRow(ctx, sqlLedgerOpenedToday, o.BranchId, o.Ledger).Scan(&today); err != nil {
+				return nil, err
+			}
+			if !today {
+				return nil, fmt.Errorf("%w: %d", ErrPreviousDayNotClosed, o.Ledger)
+			}
+			return nil, fmt.Errorf("%w: %d", ErrLedgerAlreadyOpen, o.Ledger)
+		}
+		// Anything left in liquidity beyond stock in transit was never counted out, and the float would be added to it.
+		inTransit, err := c.stockInTransitFrom(ctx, o.BranchId, o.Ledger)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			if Ledger(account.Ledger) == o.Ledger && liquidityBalance(account) != int64(inTransit) {
+				return nil, fmt.Errorf("%w: %d", ErrLedgerNotSettled, o.Ledger)
+			}
+		}
+
+		if err := tx.QueryRow(
+			ctx,
+			"INSERT INTO sod_openings (tb_pending_id, branch_id, ledger_id, operator_id, amount) VALUES ($1, $2, $3, $4, $5) RETURNING opened_at",
+			tbToUuid(o.TbPendingId),
+			o.BranchId,
+			o.Ledger,
+			o.OperatorId,
+			o.Amount,
+		).Scan(&o.OpenedAt); err != nil {
+			return nil, err
+		}
+		if err := insertCountDenominations(
+			ctx,
+			tx,
+			CountSod,
+			tbToUuid(o.TbPendingId),
+			o.Ledger,
+			counts[o.Ledger],
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.createTransfers(transfers); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfers...)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending float transfers after PG commit failed",
+				"error",
+				voidErr,
+			)
+		}
+		return nil, err
+	}
+	if err := c.createTransfers(resolvePendingTransfers(false, transfers...)); err != nil {
+		return nil, fmt.Errorf("core: openings recorded but float transfers could not be posted: %w", err)
+	}
+
+	for _, o := range openings {
+		c.Logger.Info(
+			"ledger opened",
+			"branch_id",
+			o.BranchId,
+			"ledger",
+			o.Ledger,
+			"operator_id",
+			o.OperatorId,
+			"amount",
+			o.Amount,
+		)
+	}
+	return openings, nil
+}
+
+// checkLedgersOpen returns ErrLedgerNotOpen if any of the given ledgers have not been opened in a branch, or have been
+// closed since.
+func (c *Core) checkLedgersOpen(ctx context.Context, db pgQuerier, branchId uuid.UUID, ledgers ...Ledger) error {
+	for _, ledger := range ledgers {
+		var open bool
+		if err := db.QueryRow(ctx, sqlLedgerOpen, branchId, ledger).Scan(&open); err != nil {
+			return err
+		}
+		if !open {
+			return fmt.Errorf("%w: %d", ErrLedgerNotOpen, ledger)
+		}
+	}
+
+	return nil
+}
+
+// checkLedgersTrading is checkLedgersOpen for moving cash. It also returns ErrPreviousDayNotClosed if a ledger was opened
+// before today, so a branch that never runs CloseDay can't trade on a stale day. Only CloseDay, CashUpTill and
+// RecordMiddayCount work on a stale day, to settle it.
+func (c *Core) checkLedgersTrading(ctx context.Context, db pgQuerier, branchId uuid.UUID, ledgers ...Ledger) error {
+	if err := c.checkLedgersOpen(ctx, db, branchId, ledgers...); err != nil {
+		return err
+	}
+	for _, ledger := range ledgers {
+		var today bool
+		if err := db.QueryRow(ctx, sqlLedgerOpenedToday, branchId, ledger).Scan(&today); err != nil {
+			return err
+		}
+		if !today {
+			return fmt.Errorf("%w: %d", ErrPreviousDayNotClosed, ledger)
+		}
+	}
+
+	return nil
+}
+
+// EodClosing records a ledger's counted cash at the end of the day, against what TB expected.
+type EodClosing struct {
+	TbPendingId   tbTypes.Uint128
+	BranchId      uuid.UUID
+	Ledger        Ledger
+	OperatorId    uuid.UUID
+	CountedAmount uint64 // In the ledger's minor unit
+	LedgerBalance int64  // The posted liquidity balance in TB before any adjustment, less stock in transit
+	ClosedAt      time.Time
+	// Denominations is the counted breakdown by face value, or nil if the cash was counted by total.
+	Denominations map[Denomination]uint64
+
+	// Adjustment is nil if the count matched TB.
+	Adjustment *LedgerAdjustment
+}
+
+// LedgerAdjustment records an over or short posted to bring TB in line with a physical count.
+type LedgerAdjustment struct {
+	TbTransferId tbTypes.Uint128
+	BranchId     uuid.UUID
+	Ledger       Ledger
+	OperatorId   uuid.UUID
+	TillId       uuid.UUID // Nil if the adjustment was against the safe
+	Type         AdjustmentType
+	Amount       uint64 // In the ledger's minor unit
+	Notes        string
+	CreatedAt    time.Time
+}
+
+// CloseDay closes each ledger in counts in the operator's branch, with the physically counted cash in the safe. Every till in
+// the branch must have been cashed up.
+// Any difference from the posted TB liquidity balance is posted to the branch overs or shorts account and recorded as a ledger
+// adjustment. The counted cash is then moved back from liquidity into the control account, leaving liquidity at zero for
+// the next OpenDay, apart from stock dispatched to another branch that has not yet been received. Further trading on a
+// ledger is refused until it is opened again.
+func (c *Core) CloseDay(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	counts map[Ledger]CashCount,
+) ([]EodClosing, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if len(counts) == 0 {
+		return nil, ErrInvalidAmount
+	}
+	ids, err := c.branchIds(ctx, op.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers := make([]Ledger, 0, len(counts))
+	liquidityIds := make([]tbTypes.Uint128, 0, len(counts))
+	countedAmounts := make(map[Ledger]uint64, len(counts))
+	for ledger, count := range counts {
+		amount, err := count.minorUnits(ledger, c.denominations[ledger])
+		if err != nil {
+			return nil, err
+		}
+		countedAmounts[ledger] = amount
+		ledgers = append(ledgers, ledger)
+		liquidityIds = append(liquidityIds, ids.liquidity[ledger])
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Everything from here on is checked under the lock, so a concurrent CloseDay can't close the same day twice, and no
+	// trade or float move can change the balances between reading them and recording the count.
+	if err := lockBranchDay(ctx, tx, op.BranchId); err != nil {
+		return nil, err
+	}
+	for _, ledger := range ledgers {
+		var open bool
+		if err := tx.QueryRow(ctx, sqlLedgerOpen, op.BranchId, ledger).Scan(&open); err != nil {
+			return nil, err
+		}
+		if !open {
+			return nil, fmt.Errorf("%w: %d", ErrLedgerNotOpen, ledger)
+		}
+	}
+	if err := c.checkTillsCashedUp(ctx, op.BranchId, ledgers...); err != nil {
+		return nil, err
+	}
+
+	accounts, err := c.tbc.LookupAccounts(liquidityIds)
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+	}
+	if len(accounts) != len(liquidityIds) {
+		return nil, errors.New("core: liquidity accounts not found in TB")
+	}
+
+	closings := make([]EodClosing, 0, len(ledgers))
+	transfers := []tbTypes.Transfer{}
+	for _, account := range accounts {
+		ledger := Ledger(account.Ledger)
+		// Stock dispatched to another branch stays pending against liquidity until it is received, and is no longer in the
+		// safe, so it is left out of the expected balance and carried over to the next day.
+		inTransit, err := c.stockInTransitFrom(ctx, op.BranchId, ledger)
+		if err != nil {
+			return nil, err
+		}
+		if account.DebitsPending != (tbTypes.Uint128{}) || account.CreditsPending != tbTypes.ToUint128(inTransit) {
+			return nil, fmt.Errorf("%w: %d", ErrPendingTransfers, ledger)
+		}
+
+		balance := liquidityBalance(account) - int64(inTransit)
+		counted := countedAmounts[ledger]
+		closing := EodClosing{
+			TbPendingId:   tbTypes.ID(),
+			BranchId:      op.BranchId,
+			Ledger:        ledger,
+			OperatorId:    operatorId,
+			CountedAmount: counted,
+			LedgerBalance: balance,
+			Denominations: counts[ledger].Denominations,
+		}
+
+		if adjustment, transfer := countAdjustment(
+			account,
+			balance,
+			counted,
+			operatorId,
+			ids.overs[ledger],
+			ids.shorts[ledger],
+			"End of day count",
+		); adjustment != nil {
+			adjustment.BranchId = op.BranchId
+			transfers = append(transfers, transfer)
+			closing.Adjustment = adjustment
+		}
+
+		transfers = append(transfers, tbTypes.Transfer{
+			ID:              closing.TbPendingId,
+			DebitAccountID:  ids.control[ledger],
+			CreditAccountID: account.ID,
+			Amount:          tbTypes.ToUint128(counted),
+			Ledger:          uint32(ledger),
+			Code:            uint16(TransferCodeFloat),
+		})
+		closings = append(closings, closing)
+	}
+	for i := range transfers {
+		transfers[i].Flags = tbTypes.TransferFlags{
+			Linked:  i < len(transfers)-1,
+			Pending: true,
+		}.ToUint16()
+	}
+
+	for i := range closings {
+		cl := &closings[i]
+		if cl.Adjustment != nil {
+			if err := insertLedgerAdjustment(ctx, tx, cl.Adjustment); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := tx.QueryRow(
+			ctx,
+			"INSERT INTO eod_closings (tb_pending_id, branch_id, ledger_id, operator_id, counted_amount, ledger_balance) VALUES ($1, $2, $3, $4, $5, $6) RETURNING closed_at",
+			tbToUuid(cl.TbPendingId),
+			cl.BranchId,
+			cl.Ledger,
+			cl.OperatorId,
+			cl.CountedAmount,
+			cl.LedgerBalance,
+		).Scan(&cl.ClosedAt); err != nil {
+			return nil, err
+		}
+		if err := insertCountDenominations(
+			ctx,
+			tx,
+			CountEod,
+			tbToUuid(cl.TbPendingId),
+			cl.Ledger,
+			counts[cl.Ledger],
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.createTransfers(transfers); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfers...)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending closing transfers after PG commit failed",
+				"error",
+				voidErr,
+			)
+		}
+		return nil, err
+	}
+	if err := c.createTransfers(resolvePendingTransfers(false, transfers...)); err != nil {
+		return nil, fmt.Errorf("core: closings recorded but closing transfers could not be posted: %w", err)
+	}
+
+	for _, cl := range closings {
+		if a := cl.Adjustment; a != nil {
+			c.Logger.Warn(
+				"ledger closed with a discrepancy",
+				"branch_id",
+				cl.BranchId,
+				"ledger",
+				cl.Ledger,
+				"operator_id",
+				cl.OperatorId,
+				"type",
+				a.Type,
+				"amount",
+				a.Amount,
+			)
+		} else {
+			c.Logger.Info("ledger closed", "branch_id", cl.BranchId, "ledger", cl.Ledger, "operator_id", cl.OperatorId)
+		}
+	}
+	return closings, nil
+}
+
+// countAdjustment builds the adjustment for the difference between a physical count and the balance expected in a
+// liquidity account, along with its transfer: overs are credited to oversId and shorts debited from shortsId. The
+// adjustment is nil if the count matched. The transfer's flags are left for the caller to set.
+func countAdjustment(
+	account tbTypes.Account,
+	balance int64,
+	counted uint64,
+	operatorId uuid.UUID,
+	oversId tbTypes.Uint128,
+	shortsId tbTypes.Uint128,
+	label string,
+) (*LedgerAdjustment, tbTypes.Transfer) {
+	ledger := Ledger(account.Ledger)
+	variance := int64(counted) - balance
+	if variance == 0 {
+		return nil, tbTypes.Transfer{}
+	}
+
+	adjustment := &LedgerAdjustment{
+		TbTransferId: tbTypes.ID(),
+		Ledger:       ledger,
+		OperatorId:   operatorId,
+		Type:         AdjustmentOver,
+		Amount:       uint64(variance),
+		Notes:        fmt.Sprintf("%s: counted %d, expected %d", label, counted, balance),
+	}
+	transfer := tbTypes.Transfer{
+		ID:              adjustment.TbTransferId,
+		DebitAccountID:  account.ID,
+		CreditAccountID: oversId,
+		Ledger:          uint32(ledger),
+		Code:            uint16(TransferCodeAdjustment),
+	}
+	if variance < 0 {
+		adjustment.Type = AdjustmentShort
+		adjustment.Amount = uint64(-variance)
+		transfer.DebitAccountID = shortsId
+		transfer.CreditAccountID = account.ID
+	}
+	transfer.Amount = tbTypes.ToUint128(adjustment.Amount)
+
+	return adjustment, transfer
+}
+
+// insertLedgerAdjustment records an adjustment in PG, filling in its CreatedAt.
+func insertLedgerAdjustment(ctx context.Context, tx pgx.Tx, a *LedgerAdjustment) error {
+	var tillId *uuid.UUID
+	if a.TillId != uuid.Nil {
+		tillId = &a.TillId
+	}
+
+	return tx.QueryRow(
+		ctx,
+		"INSERT INTO ledger_adjustments (tb_transfer_id, branch_id, ledger_id, operator_id, till_id, adjustment_type, amount, notes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at",
+		tbToUuid(a.TbTransferId),
+		a.BranchId,
+		a.Ledger,
+		a.OperatorId,
+		tillId,
+		a.Type,
+		a.Amount,
+		a.Notes,
+	).Scan(&a.CreatedAt)
+}
+
+// liquidityBalance is the posted balance of a liquidity account. Liquidity accounts hold cash, so they are debit-normal.
+func liquidityBalance(account tbTypes.Account) int64 {
+	debits := account.DebitsPosted.BigInt()
+	credits := account.CreditsPosted.BigInt()
+	return new(big.Int).Sub(&debits, &credits).Int64()
+}
+
+// hasPending reports whether an account has any pending debits or credits.
+func hasPending(account tbTypes.Account) bool {
+	zero := tbTypes.Uint128{}
+	return account.DebitsPending != zero || account.CreditsPending != zero
+}
+
+// TimeRange bounds a query by time. A zero From or To leaves that end unbounded.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// bounds returns the range as nullable values for PG, e.g. "($1::TIMESTAMPTZ IS NULL OR created_at >= $1)".
+func (r TimeRange) bounds() (*time.Time, *time.Time) {
+	var from, to *time.Time
+	if !r.From.IsZero() {
+		from = &r.From
+	}
+	if !r.To.IsZero() {
+		to = &r.To
+	}
+	return from, to
+}
+
+// MiddayCount records a physical cash count taken during the day against the live TB liquidity balance.
+type MiddayCount struct {
+	Id            uuid.UUID
+	BranchId      uuid.UUID
+	Ledger        Ledger
+	OperatorId    uuid.UUID
+	LedgerBalance int64  // The posted liquidity balance in TB at the time of the count
+	PhysicalCount uint64 // In the ledger's minor unit
+	CreatedAt     time.Time
+	// Denominations is the counted breakdown by face value, or nil if the cash was counted by total.
+	// ListMiddayCounts does not fill this in; use GetCountDenominations.
+	Denominations map[Denomination]uint64
+}
+
+// Variance is the physical count minus the ledger balance: positive if there is more cash than expected.
+func (m *MiddayCount) Variance() int64 {
+	return int64(m.PhysicalCount) - m.LedgerBalance
+}
+
+// RecordMiddayCount records a physical count of a ledger's cash in the operator's branch safe next to the live TB liquidity
+// balance.
+// Nothing is posted to TB; discrepancies are only settled at CloseDay. Check the returned count's Variance.
+func (c *Core) RecordMiddayCount(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	ledger Ledger,
+	count CashCount,
+) (*MiddayCount, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	physicalCount, err := count.minorUnits(ledger, c.denominations[ledger])
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkLedgersOpen(ctx, c.pgc, op.BranchId, ledger); err != nil {
+		return nil, err
+	}
+	ids, err := c.branchIds(ctx, op.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	// A single lookup reads the account's balances as of one point in TB's history.
+	accounts, err := c.tbc.LookupAccounts([]tbTypes.Uint128{ids.liquidity[ledger]})
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+	}
+	if len(accounts) != 1 {
+		return nil, errors.New("core: liquidity account not found in TB")
+	}
+
+	mc := &MiddayCount{
+		Id:            id,
+		BranchId:      op.BranchId,
+		Ledger:        ledger,
+		OperatorId:    operatorId,
+		LedgerBalance: liquidityBalance(accounts[0]),
+		PhysicalCount: physicalCount,
+		Denominations: count.Denominations,
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.QueryRow(
+		ctx,
+		"INSERT INTO midday_counts (id, branch_id, ledger_id, operator_id, ledger_balance_at_count, physical_count_recorded) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at",
+		mc.Id,
+		mc.BranchId,
+		mc.Ledger,
+		mc.OperatorId,
+		mc.LedgerBalance,
+		mc.PhysicalCount,
+	).Scan(&mc.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := insertCountDenominations(ctx, tx, CountMidday, mc.Id, ledger, count); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	if variance := mc.Variance(); variance != 0 {
+		c.Logger.Warn(
+			"midday count does not match ledger",
+			"branch_id",
+			mc.BranchId,
+			"ledger",
+			ledger,
+			"operator_id",
+			operatorId,
+			"variance",
+			variance,
+		)
+	}
+	return mc, nil
+}
+
+// ListMiddayCounts lists the midday counts recorded for a ledger in a branch within a time range, newest first.
+// A Nil branchId lists counts from every branch.
+func (c *Core) ListMiddayCounts(
+	ctx context.Context,
+	branchId uuid.UUID,
+	ledger Ledger,
+	dateRange TimeRange,
+) ([]MiddayCount, error) {
+	from, to := dateRange.bounds()
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, branch_id, ledger_id, operator_id, ledger_balance_at_count, physical_count_recorded, created_at FROM midday_counts WHERE ($1::UUID IS NULL OR branch_id = $1) AND ledger_id = $2 AND ($3::TIMESTAMPTZ IS NULL OR created_at >= $3) AND ($4::TIMESTAMPTZ IS NULL OR created_at < $4) ORDER BY created_at DESC",
+		nullableId(branchId),
+		ledger,
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (MiddayCount, error) {
+		var mc MiddayCount
+		err := row.Scan(
+			&mc.Id,
+			&mc.BranchId,
+			&mc.Ledger,
+			&mc.OperatorId,
+			&mc.LedgerBalance,
+			&mc.PhysicalCount,
+			&mc.CreatedAt,
+		)
+		return mc, err
+	})
+}
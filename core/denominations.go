@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrUnknownDenomination = errors.New("core: denomination is not registered for ledger")
+	ErrInvalidDenomination = errors.New("core: denomination must be positive and fit the ledger's asset scale")
+)
+
+// Denomination is the face value of a note or coin in the ledger's minor unit, e.g. 5000 for a £50 note.
+type Denomination uint64
+
+// CountKind represents which kind of count a denomination breakdown belongs to.
+type CountKind string
+
+const (
+	CountSod    CountKind = "SOD"
+	CountMidday CountKind = "MIDDAY"
+	CountEod    CountKind = "EOD"
+	CountTill   CountKind = "TILL"
+)
+
+// CurrencyDenominations holds the default notes and coins in circulation for each ledger, largest first. Each Core copies
+// it in New, with Options.Denominations applied on top, so changing it afterwards has no effect.
+var CurrencyDenominations = map[Ledger][]Denomination{
+	LedgerGBP: {5000, 2000, 1000, 500, 200, 100, 50, 20, 10, 5, 2, 1},
+	LedgerUSD: {10000, 5000, 2000, 1000, 500, 200, 100, 50, 25, 10, 5, 1},
+	LedgerEUR: {50000, 20000, 10000, 5000, 2000, 1000, 500, 200, 100, 50, 20, 10, 5, 2, 1},
+	LedgerJPY: {10000, 5000, 2000, 1000, 500, 100, 50, 10, 5, 1},
+}
+
+// loadDenominations builds a core's denominations from CurrencyDenominations and Options.Denominations, whose face values
+// are in display units, e.g. 50 or 0.01.
+func loadDenominations(values map[Ledger][]decimal.Decimal) (map[Ledger][]Denomination, error) {
+	all := make(map[Ledger][]Denomination, len(CurrencyDenominations)+len(values))
+	for ledger, denominations := range CurrencyDenominations {
+		all[ledger] = slices.Clone(denominations)
+	}
+
+	for ledger, faceValues := range values {
+		if _, ok := CurrencyAssetScales[ledger]; !ok {
+			return nil, ErrInvalidLedger
+		}
+		denominations := make([]Denomination, 0, len(faceValues))
+		for _, v := range faceValues {
+			if !validAmount(v, ledger) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidDenomination, v)
+			}
+			d, err := toMinorUnits(v, ledger)
+			if err != nil {
+				return nil, err
+			}
+			denominations = append(denominations, Denomination(d))
+		}
+		slices.Sort(denominations)
+		slices.Reverse(denominations)
+		all[ledger] = slices.Compact(denominations)
+	}
+
+	return all, nil
+}
+
+// Denominations gets the notes and coins that can be counted for a ledger, largest first. Ledgers without any can only be
+// counted by total.
+func (c *Core) Denominations(ledger Ledger) []Denomination {
+	return slices.Clone(c.denominations[ledger])
+}
+
+// CashCount is a physical count of one currency. Set Denominations to count by note and coin (quantities keyed by face
+// value), or Total, in display units, for a plain total. If both are set they must agree.
+type CashCount struct {
+	Total         decimal.Decimal
+	Denominations map[Denomination]uint64
+}
+
+// minorUnits validates the count against the ledger's denominations and returns its total in the ledger's minor unit.
+func (cc CashCount) minorUnits(ledger Ledger, denominations []Denomination) (uint64, error) {
+	scale, ok := CurrencyAssetScales[ledger]
+	if !ok {
+		return 0, ErrInvalidLedger
+	}
+	if cc.Total.IsNegative() || !cc.Total.Equal(cc.Total.Truncate(scale)) {
+		return 0, ErrInvalidAmount
+	}
+	countedTotal, err := toMinorUnits(cc.Total, ledger)
+	if err != nil {
+		return 0, err
+	}
+	if cc.Denominations == nil {
+		return countedTotal, nil
+	}
+
+	var total uint64
+	for d, quantity := range cc.Denominations {
+		if !slices.Contains(denominations, d) {
+			return 0, fmt.Errorf("%w: %d in ledger %d", ErrUnknownDenomination, d, ledger)
+		}
+		if quantity > 0 && uint64(d) > (math.MaxInt64-total)/quantity {
+			return 0, ErrInvalidAmount
+		}
+		total += uint64(d) * quantity
+	}
+
+	if !cc.Total.IsZero() && countedTotal != total {
+		return 0, fmt.Errorf(
+			"%w: denominations add up to %s, not %s",
+			ErrInvalidAmount,
+			fromMinorUnits(total, ledger),
+			cc.Total,
+		)
+	}
+	return total, nil
+}
+
+// insertCountDenominations stores a count's per-denomination breakdown, if it has one.
+func insertCountDenominations(
+	ctx context.Context,
+	tx pgx.Tx,
+	kind CountKind,
+	countId uuid.UUID,
+	ledger Ledger,
+	count CashCount,
+) error {
+	for d, quantity := range count.Denominations {
+		if quantity == 0 {
+			continue
+		}
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO count_denominations (count_kind, count_id, ledger_id, denomination, quantity) VALUES ($1, $2, $3, $4, $5)",
+			kind,
+			countId,
+			ledger,
+			d,
+			quantity,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetCountDenominations gets the per-denomination breakdown of a SOD, midday, EOD or till count, keyed by face value.
+// countId is the MiddayCount Id, or the SodOpening, EodClosing or TillCashUp TbPendingId as a UUID (uuid.UUID(id.Bytes())).
+// Counts recorded by total only have no breakdown.
+func (c *Core) GetCountDenominations(
+	ctx context.Context,
+	kind CountKind,
+	countId uuid.UUID,
+) (map[Denomination]uint64, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT denomination, quantity FROM count_denominations WHERE count_kind = $1 AND count_id = $2",
+		kind,
+		countId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := map[Denomination]uint64{}
+	var d Denomination
+	var quantity uint64
+	if _, err := pgx.ForEachRow(rows, []any{&d, &quantity}, func() error {
+		breakdown[d] = quantity
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return breakdown, nil
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCurrencyDenominations(t *testing.T) {
+	for ledger, denominations := range CurrencyDenominations {
+		if _, ok := CurrencyAssetScales[ledger]; !ok {
+			t.Errorf("ledger %d has denominations but no asset scale", ledger)
+		}
+		if len(denominations) == 0 {
+			t.Errorf("ledger %d has an empty list of denominations", ledger)
+		}
+		for i, d := range denominations {
+			if d == 0 {
+				t.Errorf("ledger %d has a zero denomination", ledger)
+			}
+			if i > 0 && d >= denominations[i-1] {
+				t.Errorf("ledger %d denominations are not strictly largest first: %v", ledger, denominations)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadDenominations(t *testing.T) {
+	d := decimal.RequireFromString
+	tests := []struct {
+		name    string
+		values  map[Ledger][]decimal.Decimal
+		ledger  Ledger
+		want    []Denomination
+		wantErr error
+	}{
+		{name: "defaults", ledger: LedgerGBP, want: CurrencyDenominations[LedgerGBP]},
+		{
+			name:   "override sorted and deduplicated",
+			values: map[Ledger][]decimal.Decimal{LedgerGBP: {d("0.50"), d("20"), d("5"), d("20")}},
+			ledger: LedgerGBP,
+			want:   []Denomination{2000, 500, 50},
+		},
+		{
+			name:   "new ledger",
+			values: map[Ledger][]decimal.Decimal{LedgerKWD: {d("0.005"), d("1")}},
+			ledger: LedgerKWD,
+			want:   []Denomination{1000, 5},
+		},
+		{
+			name:    "finer than the asset scale",
+			values:  map[Ledger][]decimal.Decimal{LedgerJPY: {d("0.5")}},
+			wantErr: ErrInvalidDenomination,
+		},
+		{
+			name:    "not positive",
+			values:  map[Ledger][]decimal.Decimal{LedgerGBP: {d("0")}},
+			wantErr: ErrInvalidDenomination,
+		},
+		{
+			name:    "unknown ledger",
+			values:  map[Ledger][]decimal.Decimal{Ledger(1): {d("1")}},
+			wantErr: ErrInvalidLedger,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, err := loadDenominations(tt.values)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("loadDenominations error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadDenominations error = %v", err)
+			}
+			if got := all[tt.ledger]; !slices.Equal(got, tt.want) {
+				t.Errorf("ledger %d denominations = %v, want %v", tt.ledger, got, tt.want)
+			}
+		})
+	}
+
+	all, err := loadDenominations(map[Ledger][]decimal.Decimal{LedgerGBP: {d("1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all[LedgerUSD][0] = 1
+	if CurrencyDenominations[LedgerUSD][0] == 1 {
+		t.Error("loadDenominations shares its slices with CurrencyDenominations")
+	}
+}
+
+func TestCashCountMinorUnits(t *testing.T) {
+	d := decimal.RequireFromString
+	gbp := CurrencyDenominations[LedgerGBP]
+	tests := []struct {
+		name    string
+		count   CashCount
+		ledger  Ledger
+		want    uint64
+		wantErr error
+	}{
+		{name: "total only", count: CashCount{Total: d("125.50")}, ledger: LedgerGBP, want: 12550},
+		{name: "zero total", count: CashCount{}, ledger: LedgerGBP, want: 0},
+		{
+			name:   "denominations only",
+			count:  CashCount{Denominations: map[Denomination]uint64{5000: 2, 20: 3, 1: 0}},
+			ledger: LedgerGBP,
+			want:   10060,
+		},
+		{
+			name:   "denominations agree with total",
+			count:  CashCount{Total: d("100.60"), Denominations: map[Denomination]uint64{5000: 2, 20: 3}},
+			ledger: LedgerGBP,
+			want:   10060,
+		},
+		{
+			name:    "denominations disagree with total",
+			count:   CashCount{Total: d("100"), Denominations: map[Denomination]uint64{5000: 2, 20: 3}},
+			ledger:  LedgerGBP,
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name:    "unknown denomination",
+			count:   CashCount{Denominations: map[Denomination]uint64{300: 1}},
+			ledger:  LedgerGBP,
+			wantErr: ErrUnknownDenomination,
+		},
+		{
+			name:    "negative total",
+			count:   CashCount{Total: d("-1")},
+			ledger:  LedgerGBP,
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name:    "total finer than the asset scale",
+			count:   CashCount{Total: d("1.005")},
+			ledger:  LedgerGBP,
+			wantErr: ErrInvalidAmount,
+		},
+		{
+			name:    "overflow",
+			count:   CashCount{Denominations: map[Denomination]uint64{5000: math.MaxInt64 / 1000}},
+			ledger:  LedgerGBP,
+			wantErr: ErrInvalidAmount,
+		},
+		{name: "unknown ledger", count: CashCount{Total: d("1")}, ledger: Ledger(1), wantErr: ErrInvalidLedger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.count.minorUnits(tt.ledger, gbp)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("minorUnits error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("minorUnits error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("minorUnits = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
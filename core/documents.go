@@ -0,0 +1,220 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrInvalidDocument = errors.New("core: document must have a known type, a number, a country and a future expiry date")
+	ErrDocumentExists  = errors.New("core: document is already on file")
+)
+
+// CustomerDocument is an identity document an operator has checked for a customer. A document is valid until its expiry
+// date, or until an operator expires it early, e.g. because it was reported lost.
+type CustomerDocument struct {
+	Id             uuid.UUID
+	CustomerId     uuid.UUID
+	Type           DocumentType
+	Number         string
+	IssuingCountry string    // ISO 3166-1 alpha-2, e.g. GB
+	ExpiresOn      time.Time // The date printed on the document
+	VerifiedBy     uuid.UUID
+	VerifiedAt     time.Time
+	ExpiredBy      uuid.UUID // Nil unless expired early
+	ExpiredAt      time.Time // Zero unless expired early
+}
+
+// IsValid reports whether the document could be relied on at the given time.
+func (d *CustomerDocument) IsValid(at time.Time) bool {
+	if !d.ExpiredAt.IsZero() && !at.Before(d.ExpiredAt) {
+		return false
+	}
+	return at.Before(d.ExpiresOn.AddDate(0, 0, 1))
+}
+
+type AddCustomerDocumentData struct {
+	CustomerId     uuid.UUID
+	OperatorId     uuid.UUID // The operator who checked the document
+	Type           DocumentType
+	Number         string
+	IssuingCountry string
+	ExpiresOn      time.Time
+}
+
+// AddCustomerDocument records an identity document an operator has checked for a customer.
+func (c *Core) AddCustomerDocument(ctx context.Context, data AddCustomerDocumentData) (*CustomerDocument, error) {
+	switch data.Type {
+	case DocumentPassport, DocumentDrivingLicence, DocumentNationalId, DocumentResidencePermit:
+	default:
+		return nil, ErrInvalidDocument
+	}
+	number := strings.ToUpper(strings.Join(strings.Fields(data.Number), ""))
+	country := strings.ToUpper(strings.TrimSpace(data.IssuingCountry))
+	if number == "" || len(country) != 2 || !time.Now().Before(data.ExpiresOn.AddDate(0, 0, 1)) {
+		return nil, ErrInvalidDocument
+	}
+
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if _, err := c.GetCustomerById(ctx, data.CustomerId); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	doc := &CustomerDocument{
+		Id:             id,
+		CustomerId:     data.CustomerId,
+		Type:           data.Type,
+		Number:         number,
+		IssuingCountry: country,
+		ExpiresOn:      data.ExpiresOn,
+		VerifiedBy:     op.Id,
+	}
+	if err := c.pgc.QueryRow(
+		ctx,
+		"INSERT INTO customer_documents (id, customer_id, document_type, number, issuing_country, expires_on, verified_by) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING expires_on, verified_at",
+		doc.Id,
+		doc.CustomerId,
+		doc.Type,
+		doc.Number,
+		doc.IssuingCountry,
+		doc.ExpiresOn,
+		doc.VerifiedBy,
+	).Scan(&doc.ExpiresOn, &doc.VerifiedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return nil, ErrDocumentExists
+		}
+		return nil, err
+	}
+
+	c.Logger.Info(
+		"customer document added",
+		"customer_id",
+		doc.CustomerId,
+		"document_id",
+		doc.Id,
+		"type",
+		doc.Type,
+		"verified_by",
+		doc.VerifiedBy,
+	)
+	return doc, nil
+}
+
+// ExpireCustomerDocument stops a document being accepted as ID before its expiry date, e.g. because it was reported lost
+// or found to be forged.
+func (c *Core) ExpireCustomerDocument(ctx context.Context, operatorId uuid.UUID, documentId uuid.UUID) error {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return err
+	}
+	if !op.IsActive {
+		return ErrOperatorInactive
+	}
+
+	tag, err := c.pgc.Exec(
+		ctx,
+		"UPDATE customer_documents SET expired_by = $1, expired_at = NOW() WHERE id = $2 AND expired_at IS NULL",
+		op.Id,
+		documentId,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	c.Logger.Info("customer document expired", "document_id", documentId, "operator_id", op.Id)
+	return nil
+}
+
+// GetCustomerDocument gets a document by id.
+func (c *Core) GetCustomerDocument(ctx context.Context, id uuid.UUID) (*CustomerDocument, error) {
+	return scanCustomerDocument(c.pgc.QueryRow(ctx, sqlSelectCustomerDocuments+" WHERE id = $1", id))
+}
+
+// ListCustomerDocuments gets a customer's documents, newest first. If validOnly is set, documents that have expired or been
+// expired early are left out.
+func (c *Core) ListCustomerDocuments(
+	ctx context.Context,
+	customerId uuid.UUID,
+	validOnly bool,
+) ([]CustomerDocument, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		sqlSelectCustomerDocuments+" WHERE customer_id = $1 AND (NOT $2 OR (expired_at IS NULL AND expires_on >= CURRENT_DATE)) ORDER BY verified_at DESC",
+		customerId,
+		validOnly,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (CustomerDocument, error) {
+		doc, err := scanCustomerDocument(row)
+		if err != nil {
+			return CustomerDocument{}, err
+		}
+		return *doc, nil
+	})
+}
+
+// hasValidId reports whether a customer has at least one valid, unexpired document on file.
+func (c *Core) hasValidId(ctx context.Context, customerId uuid.UUID) (bool, error) {
+	var ok bool
+	err := c.pgc.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM customer_documents WHERE customer_id = $1 AND expired_at IS NULL AND expires_on >= CURRENT_DATE)",
+		customerId,
+	).Scan(&ok)
+	return ok, err
+}
+
+const sqlSelectCustomerDocuments = "SELECT id, customer_id, document_type, number, issuing_country, expires_on, verified_by, verified_at, expired_by, expired_at FROM customer_documents"
+
+// scanCustomerDocument scans a row selected with sqlSelectCustomerDocuments.
+func scanCustomerDocument(row pgx.Row) (*CustomerDocument, error) {
+	doc := &CustomerDocument{}
+	var expiredBy *uuid.UUID
+	var expiredAt *time.Time
+	if err := row.Scan(
+		&doc.Id,
+		&doc.CustomerId,
+		&doc.Type,
+		&doc.Number,
+		&doc.IssuingCountry,
+		&doc.ExpiresOn,
+		&doc.VerifiedBy,
+		&doc.VerifiedAt,
+		&expiredBy,
+		&expiredAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if expiredBy != nil {
+		doc.ExpiredBy = *expiredBy
+	}
+	if expiredAt != nil {
+		doc.ExpiredAt = *expiredAt
+	}
+	return doc, nil
+}
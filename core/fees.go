@@ -0,0 +1,51 @@
+package core
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// FeeSchedule describes the fee charged on one direction of trade in one currency. All amounts are in the local currency's
+// display units, and are compared against the local value of the trade.
+type FeeSchedule struct {
+	Flat decimal.Decimal
+	// CommissionBps is charged on top of Flat, in hundredths of a percent of the trade's local value.
+	CommissionBps decimal.Decimal
+	// Minimum is the least that will be charged once Flat and CommissionBps are added up.
+	Minimum decimal.Decimal
+
+	// Trades worth less than WaiveBelow or at least WaiveAbove are free. Zero disables either waiver.
+	WaiveBelow decimal.Decimal
+	WaiveAbove decimal.Decimal
+}
+
+// LedgerFees holds the fee schedules for buying a foreign currency from customers and selling it to them.
+type LedgerFees struct {
+	Buy  FeeSchedule
+	Sell FeeSchedule
+}
+
+// tradeFee calculates the fee for a trade worth localAmount, rounded up to the local currency's minor unit.
+// Ledgers without a fee schedule are free.
+func (c *Core) tradeFee(
+	foreignLedger Ledger,
+	direction TradeDirection,
+	localAmount decimal.Decimal,
+) decimal.Decimal {
+	fees := c.options.Fees[foreignLedger]
+	schedule := fees.Buy
+	if direction == TradeSell {
+		schedule = fees.Sell
+	}
+
+	if schedule.WaiveBelow.IsPositive() && localAmount.LessThan(schedule.WaiveBelow) {
+		return decimal.Zero
+	}
+	if schedule.WaiveAbove.IsPositive() && localAmount.GreaterThanOrEqual(schedule.WaiveAbove) {
+		return decimal.Zero
+	}
+
+	fee := schedule.Flat.Add(localAmount.Mul(schedule.CommissionBps).Shift(-4))
+	fee = decimal.Max(fee, schedule.Minimum)
+
+	return fee.RoundCeil(CurrencyAssetScales[c.options.LocalCurrencyLedger])
+}
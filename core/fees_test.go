@@ -0,0 +1,67 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTradeFee(t *testing.T) {
+	d := decimal.RequireFromString
+	c := &Core{options: Options{
+		LocalCurrencyLedger: LedgerGBP,
+		Fees: map[Ledger]LedgerFees{
+			LedgerUSD: {
+				Buy:  FeeSchedule{Flat: d("2"), CommissionBps: d("100")},
+				Sell: FeeSchedule{CommissionBps: d("150"), Minimum: d("3"), WaiveBelow: d("10"), WaiveAbove: d("5000")},
+			},
+			LedgerEUR: {
+				Sell: FeeSchedule{Flat: d("1.50")},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name      string
+		ledger    Ledger
+		direction TradeDirection
+		amount    string
+		want      string
+	}{
+		{name: "buy flat plus commission", ledger: LedgerUSD, direction: TradeBuy, amount: "100", want: "3"},
+		{name: "buy rounds up to a penny", ledger: LedgerUSD, direction: TradeBuy, amount: "100.01", want: "3.01"},
+		{name: "buy has no waivers", ledger: LedgerUSD, direction: TradeBuy, amount: "5", want: "2.05"},
+		{name: "sell commission", ledger: LedgerUSD, direction: TradeSell, amount: "1000", want: "15"},
+		{name: "sell minimum", ledger: LedgerUSD, direction: TradeSell, amount: "100", want: "3"},
+		{name: "sell waived below", ledger: LedgerUSD, direction: TradeSell, amount: "9.99", want: "0"},
+		{name: "sell charged at waive below", ledger: LedgerUSD, direction: TradeSell, amount: "10", want: "3"},
+		{name: "sell waived at waive above", ledger: LedgerUSD, direction: TradeSell, amount: "5000", want: "0"},
+		{name: "sell charged just under waive above", ledger: LedgerUSD, direction: TradeSell, amount: "4999.99", want: "75"},
+		{name: "cross uses the buy schedule", ledger: LedgerUSD, direction: TradeCross, amount: "100", want: "3"},
+		{name: "empty buy schedule", ledger: LedgerEUR, direction: TradeBuy, amount: "100", want: "0"},
+		{name: "sell flat only", ledger: LedgerEUR, direction: TradeSell, amount: "100", want: "1.5"},
+		{name: "ledger without fees", ledger: LedgerJPY, direction: TradeSell, amount: "100", want: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.tradeFee(tt.ledger, tt.direction, d(tt.amount))
+			if !got.Equal(d(tt.want)) {
+				t.Errorf("tradeFee(%d, %s, %s) = %s, want %s", tt.ledger, tt.direction, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTradeLocalValueAppliesFeeByDirection(t *testing.T) {
+	c := &Core{options: Options{LocalCurrencyLedger: LedgerGBP}}
+
+	// A BUY pays out the local amount less the fee, and a SELL takes it plus the fee, so both come back to 100.00.
+	buy := FxTrade{Direction: TradeBuy, DebitLedger: LedgerGBP, DebitAmount: 9700, Fee: 300}
+	if got := c.tradeLocalValue(&buy); !got.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("BUY local value = %s, want 100", got)
+	}
+	sell := FxTrade{Direction: TradeSell, CreditLedger: LedgerGBP, CreditAmount: 10300, Fee: 300}
+	if got := c.tradeLocalValue(&sell); !got.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("SELL local value = %s, want 100", got)
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrInsufficientStock = errors.New("core: not enough stock available to pay out")
+	ErrBranchOpen        = errors.New("core: every ledger in the branch must be closed")
+	ErrLiquidityNotEmpty = errors.New("core: liquidity accounts must be empty, with nothing pending")
+)
+
+// InsufficientStockError is returned when a trade, float move or stock dispatch would pay out more of a currency than the
+// till or safe has available. It matches ErrInsufficientStock with errors.Is.
+type InsufficientStockError struct {
+	Ledger    Ledger
+	Available uint64 // In the ledger's minor unit, after the reserve is held back
+	Requested uint64 // In the ledger's minor unit
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf(
+		"%s: %s of ledger %d requested, %s available",
+		ErrInsufficientStock,
+		fromMinorUnits(e.Requested, e.Ledger),
+		e.Ledger,
+		fromMinorUnits(e.Available, e.Ledger),
+	)
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// Liquidity accounts are debit-normal, so paying cash out credits them. From generation 1 on they are created with
+// CreditsMustNotExceedDebits, and TB itself refuses to take them negative. Generation 0 accounts were created without it and
+// TB flags can't be changed, so they are replaced by rotating to a new generation; see RotateLiquidityAccounts.
+const guardedLiquidityGeneration = 1
+
+// liquidityKey appends the generation to a liquidity account's idWithNamespace key. Generation 0 keys are left as they were.
+func liquidityKey(key string, generation int) string {
+	if generation == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s_g%d", key, generation)
+}
+
+// liquidityFlags gets the account flags for a liquidity account of the given generation.
+func liquidityFlags(generation int) uint16 {
+	return tbTypes.AccountFlags{
+		CreditsMustNotExceedDebits: generation >= guardedLiquidityGeneration,
+		History:                    true,
+	}.ToUint16()
+}
+
+// availableStock is an account's posted balance less cash reserved to leave it by pending transfers.
+func availableStock(account tbTypes.Account) int64 {
+	reserved := account.CreditsPending.BigInt()
+	return liquidityBalance(account) - reserved.Int64()
+}
+
+// checkAvailableStock returns an *InsufficientStockError if paying out amount from a liquidity account would eat into the
+// ledger's reserve (Options.StockReserves). This also guards generation 0 accounts, which TB does not.
+func (c *Core) checkAvailableStock(ledger Ledger, accountId tbTypes.Uint128, amount uint64) error {
+	accounts, err := c.tbc.LookupAccounts([]tbTypes.Uint128{accountId})
+	if err != nil {
+		return fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+	}
+	if len(accounts) != 1 {
+		return errors.New("core: liquidity account not found in TB")
+	}
+
+	available := availableStock(accounts[0])
+	if reserve, ok := c.options.StockReserves[ledger]; ok {
+		reserveAmount, err := toMinorUnits(reserve, ledger)
+		if err != nil {
+			return err
+		}
+		available -= int64(reserveAmount)
+	}
+	if int64(amount) > available {
+		return &InsufficientStockError{
+			Ledger:    ledger,
+			Available: uint64(max(available, 0)),
+			Requested: amount,
+		}
+	}
+
+	return nil
+}
+
+// stockError maps TB refusing to overdraw a guarded liquidity account while paying out one side of a trade or a stock
+// movement to an *InsufficientStockError. Other errors are returned as they are.
+func (c *Core) stockError(err error, paidOut tradeSide) error {
+	var transferErr *TransferError
+	if !errors.As(err, &transferErr) || transferErr.Result != tbTypes.TransferExceedsDebits {
+		return err
+	}
+
+	// NOTE: another transfer took the stock between checkAvailableStock and TB. Check again for an up to date figure. If
+	// there is now enough, the shortfall has already cleared, so the original error is returned rather than a made up one.
+	if err := c.checkAvailableStock(paidOut.ledger, paidOut.liquidity, paidOut.amount); err != nil {
+		return err
+	}
+	return err
+}
+
+// RotateLiquidityAccounts moves a branch's safe and tills onto a new generation of liquidity accounts, created with
+// CreditsMustNotExceedDebits so that TB refuses to overdraw them. This is how branches created before the flag was added get
+// it. Every ledger in the branch must be closed and every till cashed up, so that the old accounts are empty; overs, shorts
+// and every other account are kept.
+//
+// Other processes running HyperFX against the same databases cache the old accounts, and must be restarted before the
+// branch is next opened.
+func (c *Core) RotateLiquidityAccounts(ctx context.Context, operatorId uuid.UUID, branchId uuid.UUID) error {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return err
+	}
+	if !op.IsActive {
+		return ErrOperatorInactive
+	}
+	if op.BranchId != branchId {
+		return ErrOperatorNotAtBranch
+	}
+
+	ledgers := make([]Ledger, 0, len(CurrencyAssetScales))
+	for ledger := range CurrencyAssetScales {
+		var open bool
+		if err := c.pgc.QueryRow(ctx, sqlLedgerOpen, branchId, ledger).Scan(&open); err != nil {
+			return err
+		}
+		if open {
+			return fmt.Errorf("%w: %d is open", ErrBranchOpen, ledger)
+		}
+		ledgers = append(ledgers, ledger)
+	}
+
+	ids, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return err
+	}
+	tills, err := c.ListTills(ctx, branchId)
+	if err != nil {
+		return err
+	}
+
+	accountIds := make([]tbTypes.Uint128, 0, len(ledgers)*(len(tills)+1))
+	for _, ledger := range ledgers {
+		accountIds = append(accountIds, ids.liquidity[ledger])
+		for _, till := range tills {
+			accountIds = append(accountIds, c.tillAccountIds(ids, till.Id, ledger).liquidity)
+		}
+	}
+	accounts, err := c.lookupAccounts(accountIds)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if liquidityBalance(account) != 0 || hasPending(account) {
+			return fmt.Errorf("%w: account %s, ledger %d", ErrLiquidityNotEmpty, account.ID, account.Ledger)
+		}
+	}
+
+	generation := max(ids.generation+1, guardedLiquidityGeneration)
+	newIds, err := initSystemAccounts(
+		c.options,
+		c.branchNamespace(branchId),
+		generation,
+		c.tbc,
+		c.Logger.With("branch_id", branchId),
+	)
+	if err != nil {
+		return err
+	}
+	for _, till := range tills {
+		if err := c.createTillAccounts(newIds, till.Id); err != nil {
+			return err
+		}
+	}
+
+	tag, err := c.pgc.Exec(
+		ctx,
+		"UPDATE branches SET liquidity_generation = $1 WHERE id = $2 AND liquidity_generation = $3",
+		generation,
+		branchId,
+		ids.generation,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return errors.New("core: branch liquidity generation was changed concurrently")
+	}
+
+	c.branchAccountsMu.Lock()
+	c.branchAccounts[branchId] = newIds
+	c.branchAccountsMu.Unlock()
+
+	c.Logger.Info("liquidity accounts rotated", "branch_id", branchId, "generation", generation)
+	return nil
+}
@@ -0,0 +1 @@
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS tb_debit_pending_id;
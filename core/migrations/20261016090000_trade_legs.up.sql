@@ -0,0 +1,3 @@
+-- tb_pending_id identifies the trade and is the leg crediting the customer (the currency they hand over).
+-- tb_debit_pending_id is the linked leg debiting the customer (the currency paid out to them).
+ALTER TABLE fx_trades ADD COLUMN tb_debit_pending_id UUID NOT NULL UNIQUE;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_fx_trades_pending_expiry;
+
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS resolved_at;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS state;
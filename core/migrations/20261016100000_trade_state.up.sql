@@ -0,0 +1,6 @@
+ALTER TABLE fx_trades ADD COLUMN state TEXT NOT NULL DEFAULT 'PENDING'
+    CHECK (state IN ('PENDING', 'POSTED', 'VOIDED', 'EXPIRED'));
+ALTER TABLE fx_trades ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE fx_trades ADD COLUMN resolved_at TIMESTAMPTZ;
+
+CREATE INDEX idx_fx_trades_pending_expiry ON fx_trades(expires_at) WHERE state = 'PENDING';
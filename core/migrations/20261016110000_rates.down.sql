@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS trg_record_rate_history ON rates;
+DROP FUNCTION IF EXISTS fn_record_rate_history();
+
+DROP TABLE IF EXISTS rate_history;
+DROP TABLE IF EXISTS rates;
@@ -0,0 +1,31 @@
+-- Mid rates, as units of the foreign currency per unit of the local currency.
+CREATE TABLE rates (
+    ledger_id INT PRIMARY KEY, -- ISO 4217
+    rate NUMERIC(18, 9) NOT NULL CHECK (rate > 0),
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE rate_history (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id INT NOT NULL,
+    rate NUMERIC(18, 9) NOT NULL,
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_rate_history_ledger_date ON rate_history(ledger_id, created_at DESC);
+
+CREATE OR REPLACE FUNCTION fn_record_rate_history()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO rate_history (ledger_id, rate, operator_id, created_at)
+    VALUES (NEW.ledger_id, NEW.rate, NEW.operator_id, NEW.updated_at);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_record_rate_history
+AFTER INSERT OR UPDATE ON rates
+FOR EACH ROW
+EXECUTE FUNCTION fn_record_rate_history();
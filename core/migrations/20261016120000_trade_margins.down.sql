@@ -0,0 +1,3 @@
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS margin_value;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS margin_kind;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS mid_rate;
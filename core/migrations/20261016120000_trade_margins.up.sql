@@ -0,0 +1,10 @@
+-- exchange_rate is the customer-facing rate; mid_rate and the margin record how it was derived.
+-- Trades booked before margins existed were priced at the mid rate.
+ALTER TABLE fx_trades ADD COLUMN mid_rate NUMERIC(18, 9);
+UPDATE fx_trades SET mid_rate = exchange_rate;
+ALTER TABLE fx_trades ALTER COLUMN mid_rate SET NOT NULL;
+
+ALTER TABLE fx_trades ADD COLUMN margin_kind TEXT NOT NULL DEFAULT 'ABSOLUTE' CHECK (margin_kind IN ('BPS', 'ABSOLUTE'));
+ALTER TABLE fx_trades ADD COLUMN margin_value NUMERIC(18, 9) NOT NULL DEFAULT 0;
+ALTER TABLE fx_trades ALTER COLUMN margin_kind DROP DEFAULT;
+ALTER TABLE fx_trades ALTER COLUMN margin_value DROP DEFAULT;
@@ -0,0 +1,3 @@
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS quote_id;
+
+DROP TABLE IF EXISTS quotes;
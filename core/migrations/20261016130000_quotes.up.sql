@@ -0,0 +1,22 @@
+CREATE TABLE quotes (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id),
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    direction TEXT NOT NULL CHECK (direction IN ('BUY', 'SELL')),
+    ledger_id INT NOT NULL, -- ISO 4217, the foreign currency
+    foreign_amount BIGINT NOT NULL,
+    local_amount BIGINT NOT NULL,
+
+    exchange_rate NUMERIC(18, 9) NOT NULL,
+    mid_rate NUMERIC(18, 9) NOT NULL,
+    margin_kind TEXT NOT NULL CHECK (margin_kind IN ('BPS', 'ABSOLUTE')),
+    margin_value NUMERIC(18, 9) NOT NULL,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_quotes_customer_id ON quotes(customer_id, created_at DESC);
+
+-- A quote can only be used to book one trade.
+ALTER TABLE fx_trades ADD COLUMN quote_id UUID UNIQUE REFERENCES quotes(id);
@@ -0,0 +1,4 @@
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS tb_fee_pending_id;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS fee;
+
+ALTER TABLE quotes DROP COLUMN IF EXISTS fee;
@@ -0,0 +1,5 @@
+-- Fees are in the local currency's minor unit.
+ALTER TABLE quotes ADD COLUMN fee BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE fx_trades ADD COLUMN fee BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE fx_trades ADD COLUMN tb_fee_pending_id UUID UNIQUE;
@@ -0,0 +1,27 @@
+-- CROSS trades can't be expressed without the counter_* columns, so refuse rather than lose them. Unused CROSS quotes are
+-- only offers, and are deleted.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM fx_trades WHERE direction = 'CROSS') THEN
+        RAISE EXCEPTION 'fx_trades holds CROSS trades, which can''t be kept once cross_trades is rolled back; reverse and remove them first';
+    END IF;
+END
+$$;
+DELETE FROM quotes WHERE direction = 'CROSS';
+
+ALTER TABLE quotes DROP CONSTRAINT quotes_direction_check;
+ALTER TABLE quotes ADD CONSTRAINT quotes_direction_check CHECK (direction IN ('BUY', 'SELL'));
+ALTER TABLE fx_trades DROP CONSTRAINT fx_trades_direction_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_direction_check CHECK (direction IN ('BUY', 'SELL'));
+
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS counter_margin_value;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS counter_margin_kind;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS counter_mid_rate;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS counter_exchange_rate;
+
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_margin_value;
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_margin_kind;
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_mid_rate;
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_exchange_rate;
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_amount;
+ALTER TABLE quotes DROP COLUMN IF EXISTS counter_ledger_id;
@@ -0,0 +1,17 @@
+ALTER TABLE fx_trades DROP CONSTRAINT fx_trades_direction_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_direction_check CHECK (direction IN ('BUY', 'SELL', 'CROSS'));
+ALTER TABLE quotes DROP CONSTRAINT quotes_direction_check;
+ALTER TABLE quotes ADD CONSTRAINT quotes_direction_check CHECK (direction IN ('BUY', 'SELL', 'CROSS'));
+
+-- CROSS only: the currency paid out and its SELL rate. The existing rate columns hold the BUY rate of the currency handed over.
+ALTER TABLE quotes ADD COLUMN counter_ledger_id INT;
+ALTER TABLE quotes ADD COLUMN counter_amount BIGINT;
+ALTER TABLE quotes ADD COLUMN counter_exchange_rate NUMERIC(18, 9);
+ALTER TABLE quotes ADD COLUMN counter_mid_rate NUMERIC(18, 9);
+ALTER TABLE quotes ADD COLUMN counter_margin_kind TEXT CHECK (counter_margin_kind IN ('BPS', 'ABSOLUTE'));
+ALTER TABLE quotes ADD COLUMN counter_margin_value NUMERIC(18, 9);
+
+ALTER TABLE fx_trades ADD COLUMN counter_exchange_rate NUMERIC(18, 9);
+ALTER TABLE fx_trades ADD COLUMN counter_mid_rate NUMERIC(18, 9);
+ALTER TABLE fx_trades ADD COLUMN counter_margin_kind TEXT CHECK (counter_margin_kind IN ('BPS', 'ABSOLUTE'));
+ALTER TABLE fx_trades ADD COLUMN counter_margin_value NUMERIC(18, 9);
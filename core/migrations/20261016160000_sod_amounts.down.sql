@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sod_openings_ledger_date;
+
+ALTER TABLE sod_openings DROP COLUMN IF EXISTS amount;
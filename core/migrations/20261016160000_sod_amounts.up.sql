@@ -0,0 +1,5 @@
+-- The counted opening float, in the ledger's minor unit.
+ALTER TABLE sod_openings ADD COLUMN amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE sod_openings ALTER COLUMN amount DROP DEFAULT;
+
+CREATE INDEX idx_sod_openings_ledger_date ON sod_openings(ledger_id, opened_at DESC);
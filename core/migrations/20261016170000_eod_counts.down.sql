@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_eod_closings_ledger_date;
+
+ALTER TABLE ledger_adjustments DROP COLUMN IF EXISTS amount;
+
+ALTER TABLE eod_closings DROP COLUMN IF EXISTS ledger_balance;
+ALTER TABLE eod_closings DROP COLUMN IF EXISTS counted_amount;
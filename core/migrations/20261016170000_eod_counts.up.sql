@@ -0,0 +1,10 @@
+-- Amounts are in the ledger's minor unit.
+ALTER TABLE eod_closings ADD COLUMN counted_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE eod_closings ADD COLUMN ledger_balance BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE eod_closings ALTER COLUMN counted_amount DROP DEFAULT;
+ALTER TABLE eod_closings ALTER COLUMN ledger_balance DROP DEFAULT;
+
+ALTER TABLE ledger_adjustments ADD COLUMN amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ledger_adjustments ALTER COLUMN amount DROP DEFAULT;
+
+CREATE INDEX idx_eod_closings_ledger_date ON eod_closings(ledger_id, closed_at DESC);
@@ -0,0 +1,10 @@
+-- Per-denomination breakdowns of SOD, midday and EOD counts. count_id is the sod_openings or eod_closings tb_pending_id, or
+-- the midday_counts id. Denominations are face values in the ledger's minor unit.
+CREATE TABLE count_denominations (
+    count_kind TEXT NOT NULL CHECK (count_kind IN ('SOD', 'MIDDAY', 'EOD')),
+    count_id UUID NOT NULL,
+    ledger_id INT NOT NULL,
+    denomination BIGINT NOT NULL CHECK (denomination > 0),
+    quantity BIGINT NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (count_kind, count_id, denomination)
+);
@@ -0,0 +1,17 @@
+DELETE FROM count_denominations WHERE count_kind = 'TILL';
+ALTER TABLE count_denominations DROP CONSTRAINT count_denominations_count_kind_check;
+ALTER TABLE count_denominations ADD CONSTRAINT count_denominations_count_kind_check
+    CHECK (count_kind IN ('SOD', 'MIDDAY', 'EOD'));
+
+DROP TABLE IF EXISTS till_cash_ups;
+DROP TABLE IF EXISTS float_transfers;
+
+DROP INDEX IF EXISTS idx_ledger_adjustments_till;
+ALTER TABLE ledger_adjustments DROP COLUMN IF EXISTS till_id;
+
+DROP INDEX IF EXISTS idx_fx_trades_till;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS till_id;
+
+ALTER TABLE operators DROP COLUMN IF EXISTS till_id;
+
+DROP TABLE IF EXISTS tills;
@@ -0,0 +1,44 @@
+CREATE TABLE tills (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- Each till belongs to at most one operator at a time, so its overs and shorts can be traced to them.
+ALTER TABLE operators ADD COLUMN till_id UUID UNIQUE REFERENCES tills(id);
+
+ALTER TABLE fx_trades ADD COLUMN till_id UUID REFERENCES tills(id);
+CREATE INDEX idx_fx_trades_till ON fx_trades(till_id, created_at) WHERE till_id IS NOT NULL;
+
+ALTER TABLE ledger_adjustments ADD COLUMN till_id UUID REFERENCES tills(id);
+CREATE INDEX idx_ledger_adjustments_till ON ledger_adjustments(till_id, created_at DESC) WHERE till_id IS NOT NULL;
+
+-- Amounts are in the ledger's minor unit.
+CREATE TABLE float_transfers (
+    tb_transfer_id UUID PRIMARY KEY,
+    till_id UUID NOT NULL REFERENCES tills(id),
+    ledger_id INT NOT NULL,
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    direction TEXT NOT NULL CHECK (direction IN ('TO_TILL', 'TO_SAFE')),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_float_transfers_till ON float_transfers(till_id, created_at DESC);
+
+CREATE TABLE till_cash_ups (
+    tb_pending_id UUID PRIMARY KEY,
+    till_id UUID NOT NULL REFERENCES tills(id),
+    ledger_id INT NOT NULL,
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    counted_amount BIGINT NOT NULL,
+    ledger_balance BIGINT NOT NULL,
+    cashed_up_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_till_cash_ups_till ON till_cash_ups(till_id, cashed_up_at DESC);
+
+ALTER TABLE count_denominations DROP CONSTRAINT count_denominations_count_kind_check;
+ALTER TABLE count_denominations ADD CONSTRAINT count_denominations_count_kind_check
+    CHECK (count_kind IN ('SOD', 'MIDDAY', 'EOD', 'TILL'));
@@ -0,0 +1,23 @@
+DROP INDEX IF EXISTS idx_ledger_adjustments_branch;
+ALTER TABLE ledger_adjustments DROP COLUMN IF EXISTS branch_id;
+
+DROP INDEX IF EXISTS idx_midday_counts_branch_ledger_date;
+ALTER TABLE midday_counts DROP COLUMN IF EXISTS branch_id;
+
+DROP INDEX IF EXISTS idx_eod_closings_branch_ledger_date;
+ALTER TABLE eod_closings DROP COLUMN IF EXISTS branch_id;
+
+DROP INDEX IF EXISTS idx_sod_openings_branch_ledger_date;
+ALTER TABLE sod_openings DROP COLUMN IF EXISTS branch_id;
+
+DROP INDEX IF EXISTS idx_fx_trades_branch;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS branch_id;
+
+ALTER TABLE tills DROP CONSTRAINT IF EXISTS tills_branch_name_key;
+ALTER TABLE tills DROP COLUMN IF EXISTS branch_id;
+ALTER TABLE tills ADD CONSTRAINT tills_name_key UNIQUE (name);
+
+DROP INDEX IF EXISTS idx_operators_branch;
+ALTER TABLE operators DROP COLUMN IF EXISTS branch_id;
+
+DROP TABLE IF EXISTS branches;
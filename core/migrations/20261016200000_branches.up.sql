@@ -0,0 +1,38 @@
+CREATE TABLE branches (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- Everything recorded before branches existed belongs to the default branch (core.DefaultBranchId).
+INSERT INTO branches (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'Default');
+
+ALTER TABLE operators ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE operators ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_operators_branch ON operators(branch_id);
+
+ALTER TABLE tills ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE tills ALTER COLUMN branch_id DROP DEFAULT;
+ALTER TABLE tills DROP CONSTRAINT tills_name_key;
+ALTER TABLE tills ADD CONSTRAINT tills_branch_name_key UNIQUE (branch_id, name);
+
+ALTER TABLE fx_trades ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE fx_trades ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_fx_trades_branch ON fx_trades(branch_id, created_at);
+
+ALTER TABLE sod_openings ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE sod_openings ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_sod_openings_branch_ledger_date ON sod_openings(branch_id, ledger_id, opened_at DESC);
+
+ALTER TABLE eod_closings ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE eod_closings ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_eod_closings_branch_ledger_date ON eod_closings(branch_id, ledger_id, closed_at DESC);
+
+ALTER TABLE midday_counts ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE midday_counts ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_midday_counts_branch_ledger_date ON midday_counts(branch_id, ledger_id, created_at DESC);
+
+ALTER TABLE ledger_adjustments ADD COLUMN branch_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES branches(id);
+ALTER TABLE ledger_adjustments ALTER COLUMN branch_id DROP DEFAULT;
+CREATE INDEX idx_ledger_adjustments_branch ON ledger_adjustments(branch_id, created_at DESC);
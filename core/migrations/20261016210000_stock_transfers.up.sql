@@ -0,0 +1,27 @@
+-- A NULL branch is a wholesale supplier, named in supplier. Amounts are in the ledger's minor unit; expected_value is in the
+-- local currency's display units.
+CREATE TABLE stock_transfers (
+    tb_pending_id UUID PRIMARY KEY,
+    tb_receipt_id UUID UNIQUE,
+    from_branch_id UUID REFERENCES branches(id),
+    to_branch_id UUID REFERENCES branches(id),
+    supplier TEXT,
+    ledger_id INT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    expected_value NUMERIC(18, 9) NOT NULL,
+    consignment_ref TEXT NOT NULL,
+    courier_ref TEXT,
+    state TEXT NOT NULL DEFAULT 'IN_TRANSIT' CHECK (state IN ('IN_TRANSIT', 'RECEIVED', 'VOIDED')),
+    dispatched_by UUID NOT NULL REFERENCES operators(id),
+    dispatched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_by UUID REFERENCES operators(id),
+    resolved_at TIMESTAMPTZ,
+
+    CHECK (from_branch_id IS NOT NULL OR to_branch_id IS NOT NULL),
+    CHECK ((from_branch_id IS NULL OR to_branch_id IS NULL) = (supplier IS NOT NULL))
+);
+
+CREATE INDEX idx_stock_transfers_from ON stock_transfers(from_branch_id, ledger_id, state);
+CREATE INDEX idx_stock_transfers_to ON stock_transfers(to_branch_id, ledger_id, state);
+CREATE INDEX idx_stock_transfers_dispatched ON stock_transfers(dispatched_at DESC);
+CREATE INDEX idx_stock_transfers_consignment ON stock_transfers(consignment_ref);
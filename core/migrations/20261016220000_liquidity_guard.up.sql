@@ -0,0 +1 @@
+ALTER TABLE branches ADD COLUMN liquidity_generation INT NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS trade_reversals;
+
+UPDATE fx_trades SET state = 'POSTED' WHERE state = 'REVERSED';
+ALTER TABLE fx_trades DROP CONSTRAINT fx_trades_state_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_state_check
+    CHECK (state IN ('PENDING', 'POSTED', 'VOIDED', 'EXPIRED'));
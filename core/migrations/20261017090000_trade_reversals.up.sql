@@ -0,0 +1,23 @@
+ALTER TABLE fx_trades DROP CONSTRAINT fx_trades_state_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_state_check
+    CHECK (state IN ('PENDING', 'POSTED', 'VOIDED', 'EXPIRED', 'REVERSED'));
+
+-- Amounts are in each ledger's minor unit; fee_refunded is in the local currency.
+CREATE TABLE trade_reversals (
+    tb_pending_id UUID PRIMARY KEY,
+    trade_id UUID NOT NULL UNIQUE REFERENCES fx_trades(tb_pending_id),
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    branch_id UUID NOT NULL REFERENCES branches(id),
+    till_id UUID REFERENCES tills(id),
+    reason TEXT NOT NULL,
+    at_buy_back_rate BOOLEAN NOT NULL,
+    returned_ledger_id INT NOT NULL,
+    returned_amount BIGINT NOT NULL CHECK (returned_amount > 0),
+    refunded_ledger_id INT NOT NULL,
+    refunded_amount BIGINT NOT NULL CHECK (refunded_amount > 0),
+    fee_refunded BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_trade_reversals_branch ON trade_reversals(branch_id, created_at DESC);
+CREATE INDEX idx_trade_reversals_operator ON trade_reversals(operator_id, created_at DESC);
@@ -0,0 +1,2 @@
+-- Keyset pagination for ListTrades, newest first.
+CREATE INDEX idx_fx_trades_keyset ON fx_trades(created_at DESC, tb_pending_id DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_customers_postcode_normalised;
+DROP INDEX IF EXISTS idx_customers_name_trgm;
+ALTER TABLE customers DROP COLUMN IF EXISTS postcode_normalised;
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Postcodes are matched upper-cased with whitespace stripped; see NormalisePostcode.
+ALTER TABLE customers ADD COLUMN postcode_normalised TEXT
+    GENERATED ALWAYS AS (upper(regexp_replace(postcode, '\s', '', 'g'))) STORED;
+
+-- Trigram indexes serve prefix, word prefix and similarity matches alike.
+CREATE INDEX idx_customers_name_trgm ON customers USING GIN (lower(full_name) gin_trgm_ops);
+CREATE INDEX idx_customers_postcode_normalised ON customers(postcode_normalised text_pattern_ops);
@@ -0,0 +1,12 @@
+-- One row per changed field. The details held at any time can be rebuilt by undoing later changes from the current row.
+CREATE TABLE customer_history (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    field TEXT NOT NULL CHECK (field IN ('FULL_NAME', 'ADDRESS', 'POSTCODE')),
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_customer_history_customer ON customer_history(customer_id, changed_at);
@@ -0,0 +1,19 @@
+CREATE TABLE customer_documents (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    document_type TEXT NOT NULL CHECK (document_type IN ('PASSPORT', 'DRIVING_LICENCE', 'NATIONAL_ID', 'RESIDENCE_PERMIT')),
+    number TEXT NOT NULL,
+    issuing_country CHAR(2) NOT NULL,
+    expires_on DATE NOT NULL,
+    verified_by UUID NOT NULL REFERENCES operators(id),
+    verified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expired_by UUID REFERENCES operators(id),
+    expired_at TIMESTAMPTZ,
+
+    CHECK ((expired_by IS NULL) = (expired_at IS NULL))
+);
+
+CREATE INDEX idx_customer_documents_customer ON customer_documents(customer_id, verified_at DESC);
+-- A document can only be on file for one customer at a time.
+CREATE UNIQUE INDEX idx_customer_documents_number ON customer_documents(document_type, issuing_country, number)
+    WHERE expired_at IS NULL;
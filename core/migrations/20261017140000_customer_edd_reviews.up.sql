@@ -0,0 +1,11 @@
+CREATE TABLE customer_edd_reviews (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    operator_id UUID NOT NULL REFERENCES operators(id),
+    source_of_funds TEXT NOT NULL,
+    notes TEXT NOT NULL,
+    reviewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_customer_edd_reviews_customer ON customer_edd_reviews(customer_id, expires_at DESC);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS sanctions_screenings;
+DROP TABLE IF EXISTS sanctions_entries;
+DROP TABLE IF EXISTS sanctions_lists;
+
+ALTER TABLE customers DROP COLUMN IF EXISTS sanctions_screened_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS nationality;
+ALTER TABLE customers DROP COLUMN IF EXISTS date_of_birth;
@@ -0,0 +1,52 @@
+ALTER TABLE customers ADD COLUMN date_of_birth DATE;
+ALTER TABLE customers ADD COLUMN nationality CHAR(2);
+-- NULL until first screened; reset when the customer's name changes.
+ALTER TABLE customers ADD COLUMN sanctions_screened_at TIMESTAMPTZ;
+
+CREATE TABLE sanctions_lists (
+    id UUID PRIMARY KEY,
+    source TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    entry_count INT NOT NULL,
+    imported_by UUID NOT NULL REFERENCES operators(id),
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    is_current BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE UNIQUE INDEX idx_sanctions_lists_current ON sanctions_lists(source) WHERE is_current;
+
+-- Aliases are separate rows sharing external_ref. name_normalised has its words sorted; see normaliseName.
+-- dob_year is set instead of date_of_birth when only the year is known.
+CREATE TABLE sanctions_entries (
+    id UUID PRIMARY KEY,
+    list_id UUID NOT NULL REFERENCES sanctions_lists(id) ON DELETE CASCADE,
+    external_ref TEXT NOT NULL,
+    name TEXT NOT NULL,
+    name_normalised TEXT NOT NULL,
+    date_of_birth DATE,
+    dob_year INT,
+    country TEXT
+);
+
+CREATE INDEX idx_sanctions_entries_list ON sanctions_entries(list_id);
+CREATE INDEX idx_sanctions_entries_name_trgm ON sanctions_entries USING GIN (name_normalised gin_trgm_ops);
+
+-- A list entry is raised at most once per customer, so cleared matches stay cleared across list imports.
+CREATE TABLE sanctions_screenings (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    source TEXT NOT NULL,
+    external_ref TEXT NOT NULL,
+    matched_name TEXT NOT NULL,
+    score REAL NOT NULL CHECK (score BETWEEN 0 AND 1),
+    state TEXT NOT NULL DEFAULT 'PENDING' CHECK (state IN ('PENDING', 'CLEARED', 'CONFIRMED')),
+    screened_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_by UUID REFERENCES operators(id),
+    resolved_at TIMESTAMPTZ,
+    resolution_notes TEXT,
+
+    UNIQUE (customer_id, source, external_ref)
+);
+
+CREATE INDEX idx_sanctions_screenings_pending ON sanctions_screenings(customer_id) WHERE state = 'PENDING';
+CREATE INDEX idx_sanctions_screenings_screened ON sanctions_screenings(screened_at DESC);
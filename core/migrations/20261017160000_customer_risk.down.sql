@@ -0,0 +1,14 @@
+DELETE FROM customer_history WHERE field IN ('IS_PEP', 'OCCUPATION');
+ALTER TABLE customer_history DROP CONSTRAINT IF EXISTS customer_history_field_check;
+ALTER TABLE customer_history ADD CONSTRAINT customer_history_field_check
+    CHECK (field IN ('FULL_NAME', 'ADDRESS', 'POSTCODE'));
+
+DROP TABLE IF EXISTS customer_risk_assessments;
+
+DROP INDEX IF EXISTS idx_customers_next_review;
+ALTER TABLE customers DROP COLUMN IF EXISTS next_review_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS risk_assessed_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS risk_reasons;
+ALTER TABLE customers DROP COLUMN IF EXISTS risk_rating;
+ALTER TABLE customers DROP COLUMN IF EXISTS occupation;
+ALTER TABLE customers DROP COLUMN IF EXISTS is_pep;
@@ -0,0 +1,25 @@
+ALTER TABLE customers ADD COLUMN is_pep BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE customers ADD COLUMN occupation TEXT;
+-- Kept up to date by AssessCustomerRisk. next_review_at is NULL until the customer is first assessed.
+ALTER TABLE customers ADD COLUMN risk_rating TEXT NOT NULL DEFAULT 'LOW' CHECK (risk_rating IN ('LOW', 'MEDIUM', 'HIGH'));
+ALTER TABLE customers ADD COLUMN risk_reasons TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE customers ADD COLUMN risk_assessed_at TIMESTAMPTZ;
+ALTER TABLE customers ADD COLUMN next_review_at TIMESTAMPTZ;
+
+CREATE INDEX idx_customers_next_review ON customers(next_review_at NULLS FIRST);
+
+-- One row per change to a customer's rating or its reasons.
+CREATE TABLE customer_risk_assessments (
+    id UUID PRIMARY KEY,
+    customer_id UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    rating TEXT NOT NULL CHECK (rating IN ('LOW', 'MEDIUM', 'HIGH')),
+    score INT NOT NULL,
+    reasons TEXT[] NOT NULL,
+    assessed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_customer_risk_assessments_customer ON customer_risk_assessments(customer_id, assessed_at DESC);
+
+ALTER TABLE customer_history DROP CONSTRAINT customer_history_field_check;
+ALTER TABLE customer_history ADD CONSTRAINT customer_history_field_check
+    CHECK (field IN ('FULL_NAME', 'ADDRESS', 'POSTCODE', 'IS_PEP', 'OCCUPATION'));
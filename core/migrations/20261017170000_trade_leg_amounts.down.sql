@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_fx_trades_legs_missing;
+DROP INDEX IF EXISTS idx_fx_trades_debit_leg;
+DROP INDEX IF EXISTS idx_fx_trades_credit_leg;
+
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS debit_amount;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS debit_ledger_id;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS credit_amount;
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS credit_ledger_id;
@@ -0,0 +1,10 @@
+-- Copies of the TB legs, so trades can be filtered by ledger and amount in PG. Trades booked before this migration are
+-- filled in from TB by backfillTradeLegs when the core starts, so the columns are nullable.
+ALTER TABLE fx_trades ADD COLUMN credit_ledger_id INT;
+ALTER TABLE fx_trades ADD COLUMN credit_amount BIGINT CHECK (credit_amount > 0);
+ALTER TABLE fx_trades ADD COLUMN debit_ledger_id INT;
+ALTER TABLE fx_trades ADD COLUMN debit_amount BIGINT CHECK (debit_amount > 0);
+
+CREATE INDEX idx_fx_trades_credit_leg ON fx_trades(credit_ledger_id, credit_amount);
+CREATE INDEX idx_fx_trades_debit_leg ON fx_trades(debit_ledger_id, debit_amount);
+CREATE INDEX idx_fx_trades_legs_missing ON fx_trades(tb_pending_id) WHERE credit_ledger_id IS NULL;
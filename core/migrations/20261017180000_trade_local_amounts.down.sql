@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_fx_trades_local_amount_missing;
+DROP INDEX IF EXISTS idx_fx_trades_customer_totals;
+
+ALTER TABLE fx_trades DROP COLUMN IF EXISTS local_amount;
@@ -0,0 +1,7 @@
+-- The local currency value of each trade before fees (its quote's LocalAmount), in the local currency's minor unit, so
+-- rolling CDD totals are a single aggregate. Trades booked before this migration are filled in by
+-- backfillTradeLocalAmounts when the core starts.
+ALTER TABLE fx_trades ADD COLUMN local_amount BIGINT CHECK (local_amount >= 0);
+
+CREATE INDEX idx_fx_trades_customer_totals ON fx_trades(customer_id, created_at) INCLUDE (state, local_amount);
+CREATE INDEX idx_fx_trades_local_amount_missing ON fx_trades(tb_pending_id) WHERE local_amount IS NULL;
@@ -0,0 +1,3 @@
+-- The up migration only restates the constraint left by 20261016150000_cross_trades, so it is put back as it was.
+ALTER TABLE fx_trades DROP CONSTRAINT IF EXISTS fx_trades_direction_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_direction_check CHECK (direction IN ('BUY', 'SELL', 'CROSS'));
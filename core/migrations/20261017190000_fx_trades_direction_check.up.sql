@@ -0,0 +1,4 @@
+-- 20251217222910_initial created this constraint against trade_type rather than direction. Replace it by name, so every
+-- database ends up checking direction whatever it was created with.
+ALTER TABLE fx_trades DROP CONSTRAINT IF EXISTS fx_trades_direction_check;
+ALTER TABLE fx_trades ADD CONSTRAINT fx_trades_direction_check CHECK (direction IN ('BUY', 'SELL', 'CROSS'));
@@ -14,11 +14,14 @@ type Operator struct {
 	PasswordHash []byte
 	CreatedAt    time.Time
 	IsActive     bool
+	BranchId     uuid.UUID
+	TillId       uuid.UUID // Nil if the operator has no till and trades from the safe
 }
 
 type CreateOperatorData struct {
 	Username string
 	Password []byte
+	BranchId uuid.UUID // Defaults to DefaultBranchId
 }
 
 // CreateOperator inserts a new operator into the database, returning the newly created operator.
@@ -33,9 +36,17 @@ func (c *Core) CreateOperator(ctx context.Context, data CreateOperatorData) (*Op
 		return nil, err
 	}
 
+	branchId := data.BranchId
+	if branchId == uuid.Nil {
+		branchId = DefaultBranchId
+	}
+	if err := c.checkBranchActive(ctx, branchId); err != nil {
+		return nil, err
+	}
+
 	var createdAt time.Time
 	var isActive bool
-	if err := c.pgc.QueryRow(ctx, "INSERT INTO operators (id, username, password_hash) VALUES ($1, $2, $3) RETURNING created_at, is_active", id, data.Username, passHash).
+	if err := c.pgc.QueryRow(ctx, "INSERT INTO operators (id, username, password_hash, branch_id) VALUES ($1, $2, $3, $4) RETURNING created_at, is_active", id, data.Username, passHash, branchId).
 		Scan(&createdAt, &isActive); err != nil {
 		return nil, err
 	}
@@ -46,16 +57,21 @@ func (c *Core) CreateOperator(ctx context.Context, data CreateOperatorData) (*Op
 		PasswordHash: passHash,
 		CreatedAt:    createdAt,
 		IsActive:     isActive,
+		BranchId:     branchId,
 	}, nil
 }
 
 // GetOperator queries the database for an operator with a matching id OR username.
 func (c *Core) GetOperator(ctx context.Context, id uuid.UUID, username string) (*Operator, error) {
 	op := &Operator{}
-	if err := c.pgc.QueryRow(ctx, "SELECT id, username, password_hash, created_at, is_active FROM operators WHERE id = $1 OR username = $2", id, username).
-		Scan(&op.Id, &op.Username, &op.PasswordHash, &op.CreatedAt, &op.IsActive); err != nil {
+	var tillId *uuid.UUID
+	if err := c.pgc.QueryRow(ctx, "SELECT id, username, password_hash, created_at, is_active, branch_id, till_id FROM operators WHERE id = $1 OR username = $2", id, username).
+		Scan(&op.Id, &op.Username, &op.PasswordHash, &op.CreatedAt, &op.IsActive, &op.BranchId, &tillId); err != nil {
 		return nil, err
 	}
+	if tillId != nil {
+		op.TillId = *tillId
+	}
 
 	return op, nil
 }
@@ -74,6 +90,23 @@ func (c *Core) SetOperatorActivated(ctx context.Context, id uuid.UUID, isActive
 	return nil
 }
 
+// SetOperatorBranch moves an operator to another branch. Their till belongs to the old branch, so it is unassigned.
+func (c *Core) SetOperatorBranch(ctx context.Context, id uuid.UUID, branchId uuid.UUID) error {
+	if err := c.checkBranchActive(ctx, branchId); err != nil {
+		return err
+	}
+	if _, err := c.pgc.Exec(
+		ctx,
+		"UPDATE operators SET branch_id = $1, till_id = NULL WHERE id = $2",
+		branchId,
+		id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // VerifyOperatorPassword queries the database for an operator's password hash and checks against the password given.
 // Returns nil on success, error otherwise.
 func (c *Core) VerifyOperatorPassword(ctx context.Context, id uuid.UUID, password []byte) error {
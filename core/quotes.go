@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+// DefaultQuoteValidity is used when Options.QuoteValidity is not set.
+const DefaultQuoteValidity = 10 * time.Minute
+
+var (
+	ErrQuoteExpired  = errors.New("core: quote has expired")
+	ErrQuoteUsed     = errors.New("core: quote has already been used to book a trade")
+	ErrQuoteMismatch = errors.New("core: quote was made for a different customer")
+)
+
+// Quote is a price offered to a customer, locked until ExpiresAt. Booking a trade from a quote uses its rate and amounts
+// exactly as quoted, including rounding.
+type Quote struct {
+	Id         uuid.UUID
+	CustomerId uuid.UUID
+	OperatorId uuid.UUID
+	Direction  TradeDirection
+
+	ForeignLedger Ledger
+	// Amounts are in display units, rounded to their ledger's asset scale.
+	ForeignAmount decimal.Decimal
+	LocalAmount   decimal.Decimal
+	Rate          AppliedRate
+	// Fee is in the local currency. For a BUY it is deducted from the LocalAmount paid out; for a SELL or CROSS it is paid on top.
+	Fee decimal.Decimal
+
+	// Only set for CROSS quotes, where the customer hands over ForeignAmount and is paid out CounterAmount.
+	// LocalAmount is then the value the two are triangulated through, and CounterRate is the SELL rate of CounterLedger.
+	CounterLedger Ledger
+	CounterAmount decimal.Decimal
+	CounterRate   AppliedRate
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// TbPendingId is the trade booked from this quote, or zero if it hasn't been used.
+	TbPendingId tbTypes.Uint128
+}
+
+// CreateQuoteData describes the exchange a customer is asking about. Exactly one of ForeignAmount or LocalAmount must be set
+// (ForeignAmount or CounterAmount for CROSS); the other is calculated, rounding in the branch's favour.
+type CreateQuoteData struct {
+	CustomerId uuid.UUID
+	OperatorId uuid.UUID
+	Direction  TradeDirection
+
+	ForeignLedger Ledger
+	ForeignAmount decimal.Decimal
+	LocalAmount   decimal.Decimal
+
+	// Only for CROSS.
+	CounterLedger Ledger
+	CounterAmount decimal.Decimal
+}
+
+// CreateQuote prices an exchange at the current rate and stores the quote in PG for audit.
+// Pass the quote's Id in ExecuteTradeData to book the trade at the quoted rate.
+func (c *Core) CreateQuote(ctx context.Context, data CreateQuoteData) (*Quote, error) {
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if _, err := c.GetCustomerById(ctx, data.CustomerId); err != nil {
+		return nil, err
+	}
+
+	quote, err := c.priceQuote(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	quote.Id, err = uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	quote.ExpiresAt = time.Now().Add(c.options.QuoteValidity)
+
+	var amounts [4]uint64
+	for i, a := range []struct {
+		amount decimal.Decimal
+		ledger Ledger
+	}{
+		{quote.ForeignAmount, quote.ForeignLedger},
+		{quote.LocalAmount, c.options.LocalCurrencyLedger},
+		{quote.Fee, c.options.LocalCurrencyLedger},
+		{quote.CounterAmount, quote.CounterLedger},
+	} {
+		if amounts[i], err = toMinorUnits(a.amount, a.ledger); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.pgc.QueryRow(
+		ctx,
+		"INSERT INTO quotes (id, customer_id, operator_id, direction, ledger_id, foreign_amount, local_amount, fee, exchange_rate, mid_rate, margin_kind, margin_value, counter_ledger_id, counter_amount, counter_exchange_rate, counter_mid_rate, counter_margin_kind, counter_margin_value, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13::INT, 0), NULLIF($14::BIGINT, 0), NULLIF($15::NUMERIC, 0), NULLIF($16::NUMERIC, 0), NULLIF($17::TEXT, ''), NULLIF($18::NUMERIC, 0), $19) RETURNING created_at",
+		quote.Id,
+		quote.CustomerId,
+		quote.OperatorId,
+		quote.Direction,
+		quote.ForeignLedger,
+		amounts[0],
+		amounts[1],
+		amounts[2],
+		quote.Rate.Rate,
+		quote.Rate.Mid,
+		quote.Rate.Margin.Kind,
+		quote.Rate.Margin.Value,
+		quote.CounterLedger,
+		amounts[3],
+		quote.CounterRate.Rate,
+		quote.CounterRate.Mid,
+		quote.CounterRate.Margin.Kind,
+		quote.CounterRate.Margin.Value,
+		quote.ExpiresAt,
+	).Scan(&quote.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// GetQuote gets a quote from PG, including the trade booked from it, if any.
+func (c *Core) GetQuote(ctx context.Context, id uuid.UUID) (*Quote, error) {
+	q := &Quote{}
+	var foreignAmount, localAmount, fee, counterAmount uint64
+	var tbPendingId *uuid.UUID
+	if err := c.pgc.QueryRow(
+		ctx,
+		"SELECT q.id, q.customer_id, q.operator_id, q.direction, q.ledger_id, q.foreign_amount, q.local_amount, q.fee, q.exchange_rate, q.mid_rate, q.margin_kind, q.margin_value, COALESCE(q.counter_ledger_id, 0), COALESCE(q.counter_amount, 0), COALESCE(q.counter_exchange_rate, 0), COALESCE(q.counter_mid_rate, 0), COALESCE(q.counter_margin_kind, ''), COALESCE(q.counter_margin_value, 0), q.created_at, q.expires_at, t.tb_pending_id FROM quotes q LEFT JOIN fx_trades t ON t.quote_id = q.id WHERE q.id = $1",
+		id,
+	).Scan(
+		&q.Id,
+		&q.CustomerId,
+		&q.OperatorId,
+		&q.Direction,
+		&q.ForeignLedger,
+		&foreignAmount,
+		&localAmount,
+		&fee,
+		&q.Rate.Rate,
+		&q.Rate.Mid,
+		&q.Rate.Margin.Kind,
+		&q.Rate.Margin.Value,
+		&q.CounterLedger,
+		&counterAmount,
+		&q.CounterRate.Rate,
+		&q.CounterRate.Mid,
+		&q.CounterRate.Margin.Kind,
+		&q.CounterRate.Margin.Value,
+		&q.CreatedAt,
+		&q.ExpiresAt,
+		&tbPendingId,
+	); err != nil {
+		return nil, err
+	}
+
+	q.ForeignAmount = fromMinorUnits(foreignAmount, q.ForeignLedger)
+	q.LocalAmount = fromMinorUnits(localAmount, c.options.LocalCurrencyLedger)
+	q.Fee = fromMinorUnits(fee, c.options.LocalCurrencyLedger)
+	q.CounterAmount = fromMinorUnits(counterAmount, q.CounterLedger)
+	if tbPendingId != nil {
+		q.TbPendingId = uuidToTb(*tbPendingId)
+	}
+	return q, nil
+}
+
+// priceQuote validates an exchange and prices it at the current rate. The returned quote has not been stored.
+func (c *Core) priceQuote(ctx context.Context, data CreateQuoteData) (*Quote, error) {
+	if data.Direction == TradeCross {
+		return c.priceCrossQuote(ctx, data)
+	}
+	if data.Direction != TradeBuy && data.Direction != TradeSell {
+		return nil, ErrInvalidDirection
+	}
+	if !c.isForeignLedger(data.ForeignLedger) {
+		return nil, ErrInvalidLedger
+	}
+
+	rate, err := c.getAppliedRate(ctx, data.ForeignLedger, data.Direction)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &Quote{
+		CustomerId:    data.CustomerId,
+		OperatorId:    data.OperatorId,
+		Direction:     data.Direction,
+		ForeignLedger: data.ForeignLedger,
+		ForeignAmount: data.ForeignAmount,
+		LocalAmount:   data.LocalAmount,
+		Rate:          rate,
+	}
+
+	switch {
+	case !data.ForeignAmount.IsZero() && data.LocalAmount.IsZero():
+		if !validAmount(data.ForeignAmount, data.ForeignLedger) {
+			return nil, ErrInvalidAmount
+		}
+		quote.LocalAmount = c.localFromForeignAtRate(data.Direction, data.ForeignAmount, rate.Rate)
+	case data.ForeignAmount.IsZero() && !data.LocalAmount.IsZero():
+		if !validAmount(data.LocalAmount, c.options.LocalCurrencyLedger) {
+			return nil, ErrInvalidAmount
+		}
+		quote.ForeignAmount = foreignFromLocalAtRate(
+			data.Direction,
+			data.LocalAmount,
+			data.ForeignLedger,
+			rate.Rate,
+		)
+	default:
+		return nil, ErrInvalidAmount
+	}
+
+	// Either side can round down to nothing for tiny amounts.
+	if !quote.ForeignAmount.IsPositive() || !quote.LocalAmount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	quote.Fee = c.tradeFee(data.ForeignLedger, data.Direction, quote.LocalAmount)
+	if data.Direction == TradeBuy && quote.Fee.GreaterThanOrEqual(quote.LocalAmount) {
+		// Nothing would be left to pay out.
+		return nil, ErrInvalidAmount
+	}
+	return quote, nil
+}
+
+// priceCrossQuote prices a CROSS exchange by buying ForeignLedger and selling CounterLedger through the local currency,
+// with each side's margin applied and each conversion rounded in the branch's favour.
+// The fee is charged as a SELL of CounterLedger.
+func (c *Core) priceCrossQuote(ctx context.Context, data CreateQuoteData) (*Quote, error) {
+	if !c.isForeignLedger(data.ForeignLedger) || !c.isForeignLedger(data.CounterLedger) ||
+		data.ForeignLedger == data.CounterLedger {
+		return nil, ErrInvalidLedger
+	}
+
+	rate, err := c.getAppliedRate(ctx, data.ForeignLedger, TradeBuy)
+	if err != nil {
+		return nil, err
+	}
+	counterRate, err := c.getAppliedRate(ctx, data.CounterLedger, TradeSell)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &Quote{
+		CustomerId:    data.CustomerId,
+		OperatorId:    data.OperatorId,
+		Direction:     TradeCross,
+		ForeignLedger: data.ForeignLedger,
+		ForeignAmount: data.ForeignAmount,
+		Rate:          rate,
+		CounterLedger: data.CounterLedger,
+		CounterAmount: data.CounterAmount,
+		CounterRate:   counterRate,
+	}
+
+	switch {
+	case !data.LocalAmount.IsZero():
+		return nil, ErrInvalidAmount
+	case !data.ForeignAmount.IsZero() && data.CounterAmount.IsZero():
+		if !validAmount(data.ForeignAmount, data.ForeignLedger) {
+			return nil, ErrInvalidAmount
+		}
+		quote.LocalAmount = c.localFromForeignAtRate(TradeBuy, data.ForeignAmount, rate.Rate)
+		quote.CounterAmount = foreignFromLocalAtRate(
+			TradeSell,
+			quote.LocalAmount,
+			data.CounterLedger,
+			counterRate.Rate,
+		)
+	case data.ForeignAmount.IsZero() && !data.CounterAmount.IsZero():
+		if !validAmount(data.CounterAmount, data.CounterLedger) {
+			return nil, ErrInvalidAmount
+		}
+		quote.LocalAmount = c.localFromForeignAtRate(TradeSell, data.CounterAmount, counterRate.Rate)
+		quote.ForeignAmount = foreignFromLocalAtRate(
+			TradeBuy,
+			quote.LocalAmount,
+			data.ForeignLedger,
+			rate.Rate,
+		)
+	default:
+		return nil, ErrInvalidAmount
+	}
+
+	if !quote.ForeignAmount.IsPositive() || !quote.LocalAmount.IsPositive() ||
+		!quote.CounterAmount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	quote.Fee = c.tradeFee(data.CounterLedger, TradeSell, quote.LocalAmount)
+	return quote, nil
+}
+
+// isForeignLedger reports whether a ledger is a known currency other than the local currency.
+func (c *Core) isForeignLedger(ledger Ledger) bool {
+	_, ok := CurrencyAssetScales[ledger]
+	return ok && ledger != c.options.LocalCurrencyLedger
+}
+
+// validAmount checks that a display amount is positive and has no more decimal places than the ledger's asset scale.
+func validAmount(amount decimal.Decimal, ledger Ledger) bool {
+	scale := CurrencyAssetScales[ledger]
+	return amount.IsPositive() && amount.Equal(amount.Truncate(scale))
+}
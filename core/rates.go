@@ -0,0 +1,275 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrNoRate        = errors.New("core: no rate available for ledger")
+	ErrRatesReadOnly = errors.New("core: rate provider does not support setting rates")
+	ErrInvalidRate   = errors.New("core: rate must be positive")
+	ErrNoRateHistory = errors.New("core: rate provider does not keep rate history")
+	ErrInvalidMargin = errors.New("core: margin must not be negative, and must leave a positive rate")
+)
+
+// RateProvider supplies mid-market exchange rates, quoted as units of the foreign currency per unit of the local currency.
+// Set Options.RateProvider to use your own; by default, rates are read from PG with PgRateProvider.
+type RateProvider interface {
+	GetRate(ctx context.Context, ledger Ledger) (decimal.Decimal, error)
+}
+
+// RateRecorder is implemented by rate providers that rates can be written to, e.g. by an operator at the start of the day.
+type RateRecorder interface {
+	SetRate(ctx context.Context, ledger Ledger, rate decimal.Decimal, operatorId uuid.UUID) error
+	GetRateHistory(ctx context.Context, ledger Ledger, since time.Time) ([]RateHistoryEntry, error)
+}
+
+type RateHistoryEntry struct {
+	Ledger     Ledger
+	Rate       decimal.Decimal
+	OperatorId uuid.UUID
+	CreatedAt  time.Time
+}
+
+// Margin is the branch's markup on the mid rate for one direction of trade.
+type Margin struct {
+	Kind  MarginKind
+	Value decimal.Decimal
+}
+
+// LedgerMargins holds the margins applied when buying a foreign currency from customers and when selling it to them.
+// The difference between the two customer-facing rates is the branch's spread.
+type LedgerMargins struct {
+	Buy  Margin
+	Sell Margin
+}
+
+// AppliedRate is a customer-facing rate along with the mid rate and margin it was derived from.
+type AppliedRate struct {
+	Mid    decimal.Decimal
+	Margin Margin
+	Rate   decimal.Decimal
+}
+
+// GetRate gets the customer-facing rate for a ledger and trade direction, i.e. the mid rate with the ledger's margin applied.
+func (c *Core) GetRate(
+	ctx context.Context,
+	ledger Ledger,
+	direction TradeDirection,
+) (decimal.Decimal, error) {
+	applied, err := c.getAppliedRate(ctx, ledger, direction)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return applied.Rate, nil
+}
+
+// getAppliedRate applies the configured margin to the mid rate.
+// Rates are foreign per local, so BUY rates sit above the mid (the customer gets less local currency for their foreign) and
+// SELL rates sit below it (the customer gets less foreign currency for their local).
+func (c *Core) getAppliedRate(
+	ctx context.Context,
+	ledger Ledger,
+	direction TradeDirection,
+) (AppliedRate, error) {
+	mid, err := c.GetMidRate(ctx, ledger)
+	if err != nil {
+		return AppliedRate{}, err
+	}
+
+	margins := c.options.Margins[ledger]
+	margin := margins.Buy
+	if direction == TradeSell {
+		margin = margins.Sell
+	}
+
+	if margin.Kind == "" {
+		margin.Kind = MarginAbsolute
+	}
+
+	rate, err := applyMargin(mid, margin, direction)
+	if err != nil {
+		return AppliedRate{}, err
+	}
+
+	return AppliedRate{Mid: mid, Margin: margin, Rate: rate}, nil
+}
+
+// applyMargin marks a rate up for a BUY or down for a SELL, so that the customer gets less either way.
+func applyMargin(rate decimal.Decimal, margin Margin, direction TradeDirection) (decimal.Decimal, error) {
+	var markup decimal.Decimal
+	switch margin.Kind {
+	case MarginBasisPoints:
+		markup = rate.Mul(margin.Value).Shift(-4)
+	case MarginAbsolute, "":
+		markup = margin.Value
+	default:
+		return decimal.Zero, fmt.Errorf("%w: unknown margin kind %q", ErrInvalidMargin, margin.Kind)
+	}
+	if markup.IsNegative() {
+		return decimal.Zero, ErrInvalidMargin
+	}
+
+	applied := rate.Add(markup)
+	if direction == TradeSell {
+		applied = rate.Sub(markup)
+	}
+	if !applied.IsPositive() {
+		return decimal.Zero, ErrInvalidMargin
+	}
+
+	return applied.Round(HfxPrecision), nil
+}
+
+// GetMidRate gets the current mid rate for a ledger from the configured RateProvider.
+func (c *Core) GetMidRate(ctx context.Context, ledger Ledger) (decimal.Decimal, error) {
+	rate, err := c.options.RateProvider.GetRate(ctx, ledger)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !rate.IsPositive() {
+		return decimal.Zero, fmt.Errorf("%w: provider returned %s for ledger %d", ErrInvalidRate, rate, ledger)
+	}
+
+	return rate, nil
+}
+
+// SetRate sets the mid rate for a ledger, if the configured RateProvider supports it.
+func (c *Core) SetRate(
+	ctx context.Context,
+	ledger Ledger,
+	rate decimal.Decimal,
+	operatorId uuid.UUID,
+) error {
+	recorder, ok := c.options.RateProvider.(RateRecorder)
+	if !ok {
+		return ErrRatesReadOnly
+	}
+	if _, ok := CurrencyAssetScales[ledger]; !ok || ledger == c.options.LocalCurrencyLedger {
+		return ErrInvalidLedger
+	}
+	if !rate.IsPositive() {
+		return ErrInvalidRate
+	}
+
+	return recorder.SetRate(ctx, ledger, rate, operatorId)
+}
+
+// GetRateHistory gets every rate set for a ledger since the given time, newest first, if the configured RateProvider keeps history.
+func (c *Core) GetRateHistory(
+	ctx context.Context,
+	ledger Ledger,
+	since time.Time,
+) ([]RateHistoryEntry, error) {
+	recorder, ok := c.options.RateProvider.(RateRecorder)
+	if !ok {
+		return nil, ErrNoRateHistory
+	}
+
+	return recorder.GetRateHistory(ctx, ledger, since)
+}
+
+// PgRateProvider reads rates from the PG rates table. Every change is copied to rate_history by a trigger.
+type PgRateProvider struct {
+	pgc *pgxpool.Pool
+}
+
+// NewPgRateProvider creates a PgRateProvider using an existing pool. The pool must be connected to a migrated HyperFX database.
+func NewPgRateProvider(pgc *pgxpool.Pool) *PgRateProvider {
+	return &PgRateProvider{pgc: pgc}
+}
+
+func (p *PgRateProvider) GetRate(ctx context.Context, ledger Ledger) (decimal.Decimal, error) {
+	var rate decimal.Decimal
+	if err := p.pgc.QueryRow(ctx, "SELECT rate FROM rates WHERE ledger_id = $1", ledger).
+		Scan(&rate); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return decimal.Zero, fmt.Errorf("%w %d", ErrNoRate, ledger)
+		}
+		return decimal.Zero, err
+	}
+
+	return rate, nil
+}
+
+func (p *PgRateProvider) SetRate(
+	ctx context.Context,
+	ledger Ledger,
+	rate decimal.Decimal,
+	operatorId uuid.UUID,
+) error {
+	_, err := p.pgc.Exec(
+		ctx,
+		"INSERT INTO rates (ledger_id, rate, operator_id) VALUES ($1, $2, $3) ON CONFLICT (ledger_id) DO UPDATE SET rate = EXCLUDED.rate, operator_id = EXCLUDED.operator_id, updated_at = NOW()",
+		ledger,
+		rate,
+		operatorId,
+	)
+	return err
+}
+
+func (p *PgRateProvider) GetRateHistory(
+	ctx context.Context,
+	ledger Ledger,
+	since time.Time,
+) ([]RateHistoryEntry, error) {
+	rows, err := p.pgc.Query(
+		ctx,
+		"SELECT ledger_id, rate, operator_id, created_at FROM rate_history WHERE ledger_id = $1 AND created_at >= $2 ORDER BY created_at DESC",
+		ledger,
+		since,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RateHistoryEntry, error) {
+		var e RateHistoryEntry
+		err := row.Scan(&e.Ledger, &e.Rate, &e.OperatorId, &e.CreatedAt)
+		return e, err
+	})
+}
+
+// StaticRateProvider serves a fixed set of rates, e.g. for tests or a branch that doesn't reprice during the day.
+type StaticRateProvider struct {
+	rates map[Ledger]decimal.Decimal
+}
+
+func NewStaticRateProvider(rates map[Ledger]decimal.Decimal) *StaticRateProvider {
+	return &StaticRateProvider{rates: rates}
+}
+
+// LoadStaticRateProvider reads rates from a JSON file mapping ISO 4217 numeric codes to rates, e.g. {"840": "1.27"}.
+func LoadStaticRateProvider(path string) (*StaticRateProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to read rates file: %w", err)
+	}
+
+	rates := map[Ledger]decimal.Decimal{}
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("core: failed to parse rates file: %w", err)
+	}
+
+	return NewStaticRateProvider(rates), nil
+}
+
+func (p *StaticRateProvider) GetRate(ctx context.Context, ledger Ledger) (decimal.Decimal, error) {
+	rate, ok := p.rates[ledger]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%w %d", ErrNoRate, ledger)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,416 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrTradeNotPosted       = errors.New("core: only posted trades can be reversed")
+	ErrTradeReversed        = errors.New("core: trade has already been reversed")
+	ErrReversalWindowPassed = errors.New("core: trade is too old to be reversed")
+	ErrReversalReason       = errors.New("core: a reason must be given to reverse a trade")
+)
+
+// TradeReversal records a posted trade being undone, e.g. when a customer returns within the cooling-off period. The
+// customer hands back what they were paid out and is refunded in the currency they handed over.
+type TradeReversal struct {
+	TbPendingId tbTypes.Uint128 // The first compensating transfer; every one has the trade's TbPendingId as its UserData128
+	TradeId     tbTypes.Uint128 // The reversed trade's TbPendingId
+	OperatorId  uuid.UUID
+	BranchId    uuid.UUID
+	TillId      uuid.UUID // Nil if the cash was handled in the safe
+	Reason      string
+	// AtBuyBackRate is set if the refund was priced with Options.BuyBackMargins rather than at the trade's original rate.
+	AtBuyBackRate bool
+	// Amounts are in each ledger's minor unit.
+	ReturnedLedger Ledger
+	ReturnedAmount uint64
+	RefundedLedger Ledger
+	RefundedAmount uint64
+	FeeRefunded    uint64 // In the local currency; zero at the buy-back rate
+	CreatedAt      time.Time
+}
+
+// ReverseTrade reverses a posted trade with compensating TB transfers that mirror its legs, paid through the operator's
+// till or the safe. The trade is marked REVERSED, and can't be reversed again.
+//
+// If Options.BuyBackMargins has an entry for a foreign ledger of the trade, the refund is priced at the original rate with
+// that margin taken, and the fee is kept. Otherwise every leg is mirrored at the original amounts, refunding the fee.
+func (c *Core) ReverseTrade(
+	ctx context.Context,
+	tradeId tbTypes.Uint128,
+	operatorId uuid.UUID,
+	reason string,
+) (*TradeReversal, error) {
+	if reason == "" {
+		return nil, ErrReversalReason
+	}
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The trade stays locked until the reversal is recorded, so concurrent reversals are serialised.
+	var (
+		branchId       uuid.UUID
+		direction      TradeDirection
+		state          TradeState
+		createdAt      time.Time
+		debitPendingId uuid.UUID
+		feePendingId   *uuid.UUID
+		rate           decimal.Decimal
+		counterRate    *decimal.Decimal
+	)
+	if err := tx.QueryRow(
+		ctx,
+		"SELECT branch_id, direction, state, created_at, tb_debit_pending_id, tb_fee_pending_id, exchange_rate, counter_exchange_rate FROM fx_trades WHERE tb_pending_id = $1 FOR UPDATE",
+		tbToUuid(tradeId),
+	).Scan(&branchId, &direction, &state, &createdAt, &debitPendingId, &feePendingId, &rate, &counterRate); err != nil {
+		return nil, err
+	}
+	switch {
+	case state == TradeReversed:
+		return nil, ErrTradeReversed
+	case state != TradePosted:
+		return nil, ErrTradeNotPosted
+	case c.options.ReversalWindow > 0 && time.Since(createdAt) > c.options.ReversalWindow:
+		return nil, ErrReversalWindowPassed
+	case op.BranchId != branchId:
+		return nil, ErrOperatorNotAtBranch
+	}
+	// As in bookTrade, the branch must be active, and its day is held so CloseDay can't count the cash halfway through.
+	if err := c.checkBranchActive(ctx, branchId); err != nil {
+		return nil, err
+	}
+	if err := shareBranchDay(ctx, tx, branchId); err != nil {
+		return nil, err
+	}
+
+	legIds := []tbTypes.Uint128{tradeId, uuidToTb(debitPendingId)}
+	if feePendingId != nil {
+		legIds = append(legIds, uuidToTb(*feePendingId))
+	}
+	legs, err := c.tbc.LookupTransfers(legIds)
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup transfers request to TB: %w", err)
+	}
+	if len(legs) != len(legIds) {
+		return nil, fmt.Errorf("core: transfers for trade %s not found in TB", tradeId)
+	}
+	creditLeg, debitLeg := legs[0], legs[1]
+
+	ids, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The customer hands back what the trade's debit leg paid out to them, and is refunded what its credit leg took in.
+	returned := tradeSide{
+		ledger:    Ledger(debitLeg.Ledger),
+		amount:    tbAmount(debitLeg.Amount),
+		customer:  debitLeg.DebitAccountID,
+		liquidity: c.liquidityId(ids, op.TillId, Ledger(debitLeg.Ledger)),
+	}
+	refunded := tradeSide{
+		ledger:    Ledger(creditLeg.Ledger),
+		amount:    tbAmount(creditLeg.Amount),
+		customer:  creditLeg.CreditAccountID,
+		liquidity: c.liquidityId(ids, op.TillId, Ledger(creditLeg.Ledger)),
+	}
+
+	reversal := &TradeReversal{
+		TbPendingId:    tbTypes.ID(),
+		TradeId:        tradeId,
+		OperatorId:     op.Id,
+		BranchId:       branchId,
+		TillId:         op.TillId,
+		Reason:         reason,
+		ReturnedLedger: returned.ledger,
+		ReturnedAmount: returned.amount,
+		RefundedLedger: refunded.ledger,
+	}
+
+	ledgers := []Ledger{returned.ledger, refunded.ledger}
+	for _, ledger := range ledgers {
+		if _, ok := c.options.BuyBackMargins[ledger]; ok {
+			reversal.AtBuyBackRate = true
+		}
+	}
+	if reversal.AtBuyBackRate {
+		refunded.amount, err = c.buyBackAmount(direction, returned, refunded.ledger, rate, counterRate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reversal.RefundedAmount = refunded.amount
+
+	var feeLeg *tbTypes.Transfer
+	var feeSide tradeSide
+	if len(legs) == 3 && !reversal.AtBuyBackRate {
+		feeLeg = &legs[2]
+		reversal.FeeRefunded = tbAmount(feeLeg.Amount)
+		feeSide = tradeSide{
+			ledger:   Ledger(feeLeg.Ledger),
+			amount:   reversal.FeeRefunded,
+			customer: feeLeg.DebitAccountID,
+		}
+		if direction == TradeCross {
+			// The fee was paid in local cash rather than taken from the customer's account, so it is refunded in cash.
+			feeSide.liquidity = c.liquidityId(ids, op.TillId, feeSide.ledger)
+			feeSide.customer = feeSide.liquidity
+		}
+	}
+
+	if feeLeg != nil {
+		ledgers = append(ledgers, feeSide.ledger)
+	}
+	if err := c.checkLedgersTrading(ctx, tx, branchId, ledgers...); err != nil {
+		return nil, err
+	}
+	if err := c.checkAvailableStock(refunded.ledger, refunded.liquidity, refunded.amount); err != nil {
+		return nil, err
+	}
+	if feeSide.liquidity != (tbTypes.Uint128{}) {
+		if err := c.checkAvailableStock(feeSide.ledger, feeSide.liquidity, feeSide.amount); err != nil {
+			return nil, err
+		}
+	}
+
+	transfers := []tbTypes.Transfer{
+		{
+			ID:              reversal.TbPendingId,
+			DebitAccountID:  returned.liquidity,
+			CreditAccountID: returned.customer,
+			Amount:          tbTypes.ToUint128(returned.amount),
+			UserData128:     tradeId,
+			Ledger:          uint32(returned.ledger),
+			Code:            uint16(TransferCodeReversal),
+			Flags: tbTypes.TransferFlags{
+				Linked:  true,
+				Pending: true,
+			}.ToUint16(),
+		},
+		{
+			ID:              tbTypes.ID(),
+			DebitAccountID:  refunded.customer,
+			CreditAccountID: refunded.liquidity,
+			Amount:          tbTypes.ToUint128(refunded.amount),
+			UserData128:     tradeId,
+			Ledger:          uint32(refunded.ledger),
+			Code:            uint16(TransferCodeReversal),
+			Flags: tbTypes.TransferFlags{
+				Linked:  feeLeg != nil,
+				Pending: true,
+			}.ToUint16(),
+		},
+	}
+	if feeLeg != nil {
+		transfers = append(transfers, tbTypes.Transfer{
+			ID:              tbTypes.ID(),
+			DebitAccountID:  feeLeg.CreditAccountID,
+			CreditAccountID: feeSide.customer,
+			Amount:          feeLeg.Amount,
+			UserData128:     tradeId,
+			Ledger:          feeLeg.Ledger,
+			Code:            uint16(TransferCodeReversal),
+			Flags: tbTypes.TransferFlags{
+				Pending: true,
+			}.ToUint16(),
+		})
+	}
+
+	if err := c.createTransfers(transfers); err != nil {
+		var transferErr *TransferError
+		if errors.As(err, &transferErr) && transferErr.Index == 2 {
+			return nil, c.stockError(err, feeSide)
+		}
+		return nil, c.stockError(err, refunded)
+	}
+
+	if err := c.insertTradeReversal(ctx, tx, reversal); err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfers...)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending reversal transfers after PG insert failed",
+				"tb_pending_id",
+				reversal.TbPendingId,
+				"error",
+				voidErr,
+			)
+		}
+		return nil, err
+	}
+	// Checked before posting, while the stock reflects the refund but not yet the cash handed back.
+	paidOut := []tradeSide{refunded}
+	if feeSide.liquidity != (tbTypes.Uint128{}) {
+		paidOut = append(paidOut, feeSide)
+	}
+	c.checkTradeStockLevels(ctx, branchId, returned, paidOut...)
+
+	if err := c.createTransfers(resolvePendingTransfers(false, transfers...)); err != nil {
+		return nil, fmt.Errorf("core: trade reversal recorded but could not be posted: %w", err)
+	}
+
+	c.Logger.Info(
+		"trade reversed",
+		"tb_pending_id",
+		tradeId,
+		"branch_id",
+		branchId,
+		"at_buy_back_rate",
+		reversal.AtBuyBackRate,
+		"returned_amount",
+		reversal.ReturnedAmount,
+		"refunded_amount",
+		reversal.RefundedAmount,
+		"fee_refunded",
+		reversal.FeeRefunded,
+	)
+	return reversal, nil
+}
+
+// buyBackAmount prices the refund for a reversal at the trade's original rates with Options.BuyBackMargins taken, rounding
+// in the branch's favour. The branch buys back what it paid out and sells back what it took in, so each rate is moved
+// the opposite way to the original trade's.
+func (c *Core) buyBackAmount(
+	direction TradeDirection,
+	returned tradeSide,
+	refundedLedger Ledger,
+	rate decimal.Decimal,
+	counterRate *decimal.Decimal,
+) (uint64, error) {
+	returnedAmount := fromMinorUnits(returned.amount, returned.ledger)
+
+	var refund decimal.Decimal
+	switch direction {
+	case TradeBuy:
+		bbRate, err := applyMargin(rate, c.options.BuyBackMargins[refundedLedger], TradeSell)
+		if err != nil {
+			return 0, err
+		}
+		refund = foreignFromLocalAtRate(TradeSell, returnedAmount, refundedLedger, bbRate)
+	case TradeSell:
+		bbRate, err := applyMargin(rate, c.options.BuyBackMargins[returned.ledger], TradeBuy)
+		if err != nil {
+			return 0, err
+		}
+		refund = c.localFromForeignAtRate(TradeBuy, returnedAmount, bbRate)
+	case TradeCross:
+		if counterRate == nil {
+			return 0, errors.New("core: CROSS trade has no counter rate")
+		}
+		counterBbRate, err := applyMargin(*counterRate, c.options.BuyBackMargins[returned.ledger], TradeBuy)
+		if err != nil {
+			return 0, err
+		}
+		bbRate, err := applyMargin(rate, c.options.BuyBackMargins[refundedLedger], TradeSell)
+		if err != nil {
+			return 0, err
+		}
+		local := c.localFromForeignAtRate(TradeBuy, returnedAmount, counterBbRate)
+		refund = foreignFromLocalAtRate(TradeSell, local, refundedLedger, bbRate)
+	default:
+		return 0, ErrInvalidDirection
+	}
+
+	if !refund.IsPositive() {
+		return 0, ErrInvalidAmount
+	}
+	return toMinorUnits(refund, refundedLedger)
+}
+
+// insertTradeReversal records a reversal and marks its trade REVERSED.
+func (c *Core) insertTradeReversal(ctx context.Context, tx pgx.Tx, r *TradeReversal) error {
+	var tillId *uuid.UUID
+	if r.TillId != uuid.Nil {
+		tillId = &r.TillId
+	}
+
+	if err := tx.QueryRow(
+		ctx,
+		"INSERT INTO trade_reversals (tb_pending_id, trade_id, operator_id, branch_id, till_id, reason, at_buy_back_rate, returned_ledger_id, returned_amount, refunded_ledger_id, refunded_amount, fee_refunded) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING created_at",
+		tbToUuid(r.TbPendingId),
+		tbToUuid(r.TradeId),
+		r.OperatorId,
+		r.BranchId,
+		tillId,
+		r.Reason,
+		r.AtBuyBackRate,
+		r.ReturnedLedger,
+		r.ReturnedAmount,
+		r.RefundedLedger,
+		r.RefundedAmount,
+		r.FeeRefunded,
+	).Scan(&r.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation &&
+			pgErr.ConstraintName == "trade_reversals_trade_id_key" {
+			return ErrTradeReversed
+		}
+		return err
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		"UPDATE fx_trades SET state = $1 WHERE tb_pending_id = $2",
+		TradeReversed,
+		tbToUuid(r.TradeId),
+	)
+	return err
+}
+
+// GetTradeReversal gets the reversal of a trade by the trade's TbPendingId.
+func (c *Core) GetTradeReversal(ctx context.Context, tradeId tbTypes.Uint128) (*TradeReversal, error) {
+	r := &TradeReversal{}
+	var tbPendingId, tradeUuid uuid.UUID
+	var tillId *uuid.UUID
+	if err := c.pgc.QueryRow(
+		ctx,
+		"SELECT tb_pending_id, trade_id, operator_id, branch_id, till_id, reason, at_buy_back_rate, returned_ledger_id, returned_amount, refunded_ledger_id, refunded_amount, fee_refunded, created_at FROM trade_reversals WHERE trade_id = $1",
+		tbToUuid(tradeId),
+	).Scan(
+		&tbPendingId,
+		&tradeUuid,
+		&r.OperatorId,
+		&r.BranchId,
+		&tillId,
+		&r.Reason,
+		&r.AtBuyBackRate,
+		&r.ReturnedLedger,
+		&r.ReturnedAmount,
+		&r.RefundedLedger,
+		&r.RefundedAmount,
+		&r.FeeRefunded,
+		&r.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	r.TbPendingId = uuidToTb(tbPendingId)
+	r.TradeId = uuidToTb(tradeUuid)
+	if tillId != nil {
+		r.TillId = *tillId
+	}
+
+	return r, nil
+}
@@ -0,0 +1,404 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// riskVolumeWindow is how far back a customer's trades count towards RiskPolicy.MediumVolume and HighVolume.
+const riskVolumeWindow = 365 * 24 * time.Hour
+
+// Points each risk factor adds to a customer's risk score. A score of riskScoreMedium or more rates the customer MEDIUM,
+// and riskScoreHigh or more HIGH, so being a PEP alone is enough for HIGH.
+const (
+	riskPointsPep          = 3
+	riskPointsOccupation   = 2
+	riskPointsNationality  = 2
+	riskPointsMediumVolume = 1
+	riskPointsHighVolume   = 2
+	riskPointsUnusual      = 2
+
+	riskScoreMedium = 1
+	riskScoreHigh   = 3
+)
+
+// RiskPolicy sets how AssessCustomerRisk rates customers, and what each rating means for them.
+type RiskPolicy struct {
+	// HighRiskOccupations raise the risk of customers with one of them as their occupation, ignoring case, e.g.
+	// "money service business".
+	HighRiskOccupations []string
+	// HighRiskCountries raise the risk of customers with one of them (ISO 3166-1 alpha-2) as their nationality.
+	HighRiskCountries []string
+	// MediumVolume and HighVolume raise the risk of customers who trade more than them over 365 days, in the local
+	// currency. Zero turns either off.
+	MediumVolume decimal.Decimal
+	HighVolume   decimal.Decimal
+
+	// Cdd holds the CDD policy for customers with each rating, in place of Options.Cdd. Ratings without an entry use
+	// Options.Cdd, and an entry's EddValidity defaults to that of Options.Cdd. EddValidity is also how often customers
+	// with the rating are due an EDD review.
+	Cdd map[RiskRating]CddPolicy
+}
+
+// ratesVolume reports whether trading volume counts towards a customer's risk.
+func (p *RiskPolicy) ratesVolume() bool {
+	return p.MediumVolume.IsPositive() || p.HighVolume.IsPositive()
+}
+
+// setDefaults fills in the EddValidity of each rating's CDD policy from the default one. The map is copied so the
+// caller's Options are left alone.
+func (p *RiskPolicy) setDefaults(cdd CddPolicy) {
+	policies := make(map[RiskRating]CddPolicy, len(p.Cdd))
+	for rating, policy := range p.Cdd {
+		if policy.EddValidity == 0 {
+			policy.EddValidity = cdd.EddValidity
+		}
+		policies[rating] = policy
+	}
+	p.Cdd = policies
+}
+
+// riskScore adds up the points for each of a customer's risk factors, given their volume over riskVolumeWindow and how
+// many of their trades are unusual, and lists the reasons for them.
+func (p *RiskPolicy) riskScore(cust *Customer, volume decimal.Decimal, unusual int) (int, []string) {
+	score := 0
+	reasons := []string{}
+	if cust.IsPep {
+		score += riskPointsPep
+		reasons = append(reasons, "Politically exposed person")
+	}
+	if cust.Occupation != "" && slices.ContainsFunc(p.HighRiskOccupations, func(o string) bool {
+		return strings.EqualFold(strings.TrimSpace(o), strings.TrimSpace(cust.Occupation))
+	}) {
+		score += riskPointsOccupation
+		reasons = append(reasons, "High-risk occupation: "+cust.Occupation)
+	}
+	if cust.Nationality != "" && slices.ContainsFunc(p.HighRiskCountries, func(country string) bool {
+		return strings.EqualFold(country, cust.Nationality)
+	}) {
+		score += riskPointsNationality
+		reasons = append(reasons, "High-risk nationality: "+cust.Nationality)
+	}
+
+	// The reason names the threshold rather than the volume, so it doesn't change with every trade.
+	switch {
+	case p.HighVolume.IsPositive() && volume.GreaterThan(p.HighVolume):
+		score += riskPointsHighVolume
+		reasons = append(reasons, fmt.Sprintf("Traded over %s in 365 days", p.HighVolume))
+	case p.MediumVolume.IsPositive() && volume.GreaterThan(p.MediumVolume):
+		score += riskPointsMediumVolume
+		reasons = append(reasons, fmt.Sprintf("Traded over %s in 365 days", p.MediumVolume))
+	}
+
+	if unusual > 0 {
+		score += riskPointsUnusual
+		reasons = append(reasons, strconv.Itoa(unusual)+" unusual trade(s)")
+	}
+	return score, reasons
+}
+
+// riskRating gets the rating for a risk score.
+func riskRating(score int) RiskRating {
+	switch {
+	case score >= riskScoreHigh:
+		return RiskHigh
+	case score >= riskScoreMedium:
+		return RiskMedium
+	}
+	return RiskLow
+}
+
+// cddPolicy gets the CDD policy for customers with a risk rating.
+func (c *Core) cddPolicy(rating RiskRating) CddPolicy {
+	if policy, ok := c.options.Risk.Cdd[rating]; ok {
+		return policy
+	}
+	return c.options.Cdd
+}
+
+// RiskAssessment is a customer's risk rating and the reasons for it. A new one is recorded whenever either changes.
+type RiskAssessment struct {
+	Id         uuid.UUID // Nil if AssessCustomerRisk found nothing had changed, so recorded nothing
+	CustomerId uuid.UUID
+	Rating     RiskRating
+	Score      int
+	// Reasons lists the risk factors that applied, e.g. "Politically exposed person". It is empty for a LOW rating.
+	Reasons    []string
+	AssessedAt time.Time
+	// NextReviewAt is when the customer's next EDD review is due: when their latest review expires, or if they have never
+	// had one, when they were created plus the EddValidity of the CDD policy for the rating.
+	NextReviewAt time.Time
+}
+
+// AssessCustomerRisk rates a customer's risk from their PEP status, occupation, nationality, trading volume over the last
+// 365 days and whether any of their trades have been marked unusual, under Options.Risk. The rating and reasons are
+// stored on the customer along with their next review date, and recorded in their risk history if either has changed.
+//
+// Customers are assessed when they are created, whenever they trade or their risk profile changes, and after an EDD
+// review, so it rarely needs calling directly.
+func (c *Core) AssessCustomerRisk(ctx context.Context, customerId uuid.UUID) (*RiskAssessment, error) {
+	cust, err := c.GetCustomerById(ctx, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.assessCustomerRisk(ctx, c.pgc, cust, nil)
+}
+
+// assessCustomerRisk assesses a customer as AssessCustomerRisk does, reading and writing through db. If totals holds the
+// customer's riskVolumeWindow total, e.g. from checkCddThresholds when booking a trade, it is used rather than summed
+// again.
+func (c *Core) assessCustomerRisk(
+	ctx context.Context,
+	db pgQuerier,
+	cust *Customer,
+	totals map[time.Duration]decimal.Decimal,
+) (*RiskAssessment, error) {
+	customerId := cust.Id
+	policy := c.options.Risk
+
+	var volume decimal.Decimal
+	if policy.ratesVolume() {
+		var ok bool
+		if volume, ok = totals[riskVolumeWindow]; !ok {
+			summed, err := c.rollingTotals(ctx, db, customerId, decimal.Zero, riskVolumeWindow)
+			if err != nil {
+				return nil, err
+			}
+			volume = summed[riskVolumeWindow]
+		}
+	}
+
+	var unusual int
+	if err := db.QueryRow(ctx, "SELECT COUNT(*) FROM fx_trades WHERE customer_id = $1 AND is_unusual", customerId).
+		Scan(&unusual); err != nil {
+		return nil, err
+	}
+
+	score, reasons := policy.riskScore(cust, volume, unusual)
+	rating := riskRating(score)
+
+	// The review date follows the same clock as hasCurrentEdd, so a customer is due a review when their last one lapses.
+	var reviewExpiresAt *time.Time
+	if err := db.QueryRow(
+		ctx,
+		"SELECT (SELECT expires_at FROM customer_edd_reviews WHERE customer_id = $1 ORDER BY reviewed_at DESC LIMIT 1)",
+		customerId,
+	).Scan(&reviewExpiresAt); err != nil {
+		return nil, err
+	}
+
+	assessment := &RiskAssessment{
+		CustomerId: customerId,
+		Rating:     rating,
+		Score:      score,
+		Reasons:    reasons,
+	}
+	if reviewExpiresAt != nil {
+		assessment.NextReviewAt = *reviewExpiresAt
+	} else {
+		assessment.NextReviewAt = cust.CreatedAt.Add(c.cddPolicy(rating).EddValidity)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldRating RiskRating
+	var oldReasons []string
+	if err := tx.QueryRow(ctx, "SELECT risk_rating, risk_reasons FROM customers WHERE id = $1 FOR UPDATE", customerId).
+		Scan(&oldRating, &oldReasons); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(
+		ctx,
+		"UPDATE customers SET risk_rating = $1, risk_reasons = $2, risk_assessed_at = NOW(), next_review_at = $3 WHERE id = $4 RETURNING risk_assessed_at",
+		assessment.Rating,
+		assessment.Reasons,
+		assessment.NextReviewAt,
+		customerId,
+	).Scan(&assessment.AssessedAt); err != nil {
+		return nil, err
+	}
+
+	changed := oldRating != rating || !slices.Equal(oldReasons, reasons)
+	if changed {
+		if assessment.Id, err = uuid.NewV7(); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO customer_risk_assessments (id, customer_id, rating, score, reasons, assessed_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			assessment.Id,
+			assessment.CustomerId,
+			assessment.Rating,
+			assessment.Score,
+			assessment.Reasons,
+			assessment.AssessedAt,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	if changed {
+		c.Logger.Info(
+			"customer risk assessed",
+			"customer_id",
+			customerId,
+			"rating",
+			rating,
+			"previous_rating",
+			oldRating,
+			"score",
+			score,
+		)
+	}
+	return assessment, nil
+}
+
+// ListRiskAssessments gets every change to a customer's risk rating or its reasons, newest first. NextReviewAt is not
+// kept, so it is left zero.
+func (c *Core) ListRiskAssessments(ctx context.Context, customerId uuid.UUID) ([]RiskAssessment, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, customer_id, rating, score, reasons, assessed_at FROM customer_risk_assessments WHERE customer_id = $1 ORDER BY assessed_at DESC, id DESC",
+		customerId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RiskAssessment, error) {
+		var a RiskAssessment
+		err := row.Scan(&a.Id, &a.CustomerId, &a.Rating, &a.Score, &a.Reasons, &a.AssessedAt)
+		return a, err
+	})
+}
+
+type RiskProfileData struct {
+	OperatorId uuid.UUID
+	IsPep      bool
+	Occupation string // Empty to clear
+}
+
+// SetCustomerRiskProfile sets whether a customer is a politically exposed person and their occupation, writing each changed
+// field to customer_history, then reassesses their risk.
+func (c *Core) SetCustomerRiskProfile(
+	ctx context.Context,
+	customerId uuid.UUID,
+	data RiskProfileData,
+) (*RiskAssessment, error) {
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	occupation := strings.TrimSpace(data.Occupation)
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cust, err := scanCustomer(
+		tx.QueryRow(ctx, "SELECT "+sqlCustomerColumns+" FROM customers c WHERE c.id = $1 FOR UPDATE", customerId),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := 0
+	for _, f := range []struct {
+		field    CustomerField
+		oldValue string
+		newValue string
+	}{
+		{CustomerFieldIsPep, strconv.FormatBool(cust.IsPep), strconv.FormatBool(data.IsPep)},
+		{CustomerFieldOccupation, cust.Occupation, occupation},
+	} {
+		if f.oldValue == f.newValue {
+			continue
+		}
+		changeId, err := uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO customer_history (id, customer_id, field, old_value, new_value, operator_id) VALUES ($1, $2, $3, $4, $5, $6)",
+			changeId,
+			customerId,
+			f.field,
+			f.oldValue,
+			f.newValue,
+			op.Id,
+		); err != nil {
+			return nil, err
+		}
+		changes++
+	}
+	if changes > 0 {
+		if _, err := tx.Exec(
+			ctx,
+			"UPDATE customers SET is_pep = $1, occupation = NULLIF($2, '') WHERE id = $3",
+			data.IsPep,
+			occupation,
+			customerId,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	if changes > 0 {
+		c.Logger.Info("customer risk profile updated", "customer_id", customerId, "operator_id", op.Id, "changes", changes)
+	}
+	return c.AssessCustomerRisk(ctx, customerId)
+}
+
+// ListCustomersDueReview gets customers whose next EDD review is due by the given time, most overdue first. Customers whose
+// risk has never been assessed come first, as they have no review date. Results are paged by offset, with limit capped at
+// MaxCustomersPage.
+func (c *Core) ListCustomersDueReview(ctx context.Context, by time.Time, offset int, limit int) ([]Customer, error) {
+	if limit <= 0 || limit > MaxCustomersPage {
+		limit = MaxCustomersPage
+	}
+	offset = max(offset, 0)
+
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT "+sqlCustomerColumns+" FROM customers c WHERE c.next_review_at IS NULL OR c.next_review_at <= $1 ORDER BY c.next_review_at NULLS FIRST, c.id OFFSET $2 LIMIT $3",
+		by,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Customer, error) {
+		cust, err := scanCustomer(row)
+		if err != nil {
+			return Customer{}, err
+		}
+		return *cust, nil
+	})
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRiskScore(t *testing.T) {
+	policy := RiskPolicy{
+		HighRiskOccupations: []string{" Money Service Business "},
+		HighRiskCountries:   []string{"IR", "kp"},
+		MediumVolume:        decimal.NewFromInt(10000),
+		HighVolume:          decimal.NewFromInt(50000),
+	}
+	tests := []struct {
+		name        string
+		policy      RiskPolicy
+		cust        Customer
+		volume      int64
+		unusual     int
+		wantScore   int
+		wantRating  RiskRating
+		wantReasons []string
+	}{
+		{name: "nothing", policy: policy, wantScore: 0, wantRating: RiskLow, wantReasons: []string{}},
+		{
+			name:        "PEP alone is high",
+			policy:      policy,
+			cust:        Customer{IsPep: true},
+			wantScore:   3,
+			wantRating:  RiskHigh,
+			wantReasons: []string{"Politically exposed person"},
+		},
+		{
+			name:        "occupation ignores case and whitespace",
+			policy:      policy,
+			cust:        Customer{Occupation: "money service business"},
+			wantScore:   2,
+			wantRating:  RiskMedium,
+			wantReasons: []string{"High-risk occupation: money service business"},
+		},
+		{
+			name:        "nationality ignores case",
+			policy:      policy,
+			cust:        Customer{Nationality: "KP"},
+			wantScore:   2,
+			wantRating:  RiskMedium,
+			wantReasons: []string{"High-risk nationality: KP"},
+		},
+		{name: "other nationality", policy: policy, cust: Customer{Nationality: "GB"}, wantRating: RiskLow, wantReasons: []string{}},
+		{
+			name:        "medium volume",
+			policy:      policy,
+			volume:      10001,
+			wantScore:   1,
+			wantRating:  RiskMedium,
+			wantReasons: []string{"Traded over 10000 in 365 days"},
+		},
+		{name: "at medium volume", policy: policy, volume: 10000, wantRating: RiskLow, wantReasons: []string{}},
+		{
+			name:        "high volume replaces medium",
+			policy:      policy,
+			volume:      50001,
+			wantScore:   2,
+			wantRating:  RiskMedium,
+			wantReasons: []string{"Traded over 50000 in 365 days"},
+		},
+		{
+			name:        "high volume only",
+			policy:      RiskPolicy{HighVolume: decimal.NewFromInt(50000)},
+			volume:      40000,
+			wantRating:  RiskLow,
+			wantReasons: []string{},
+		},
+		{
+			name:        "unusual trades",
+			policy:      policy,
+			unusual:     2,
+			wantScore:   2,
+			wantRating:  RiskMedium,
+			wantReasons: []string{"2 unusual trade(s)"},
+		},
+		{
+			name:       "factors add up to high",
+			policy:     policy,
+			cust:       Customer{Occupation: "Money service business", Nationality: "IR"},
+			volume:     20000,
+			unusual:    1,
+			wantScore:  7,
+			wantRating: RiskHigh,
+			wantReasons: []string{
+				"High-risk occupation: Money service business",
+				"High-risk nationality: IR",
+				"Traded over 10000 in 365 days",
+				"1 unusual trade(s)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, reasons := tt.policy.riskScore(&tt.cust, decimal.NewFromInt(tt.volume), tt.unusual)
+			if score != tt.wantScore {
+				t.Errorf("riskScore score = %d, want %d", score, tt.wantScore)
+			}
+			if !slices.Equal(reasons, tt.wantReasons) {
+				t.Errorf("riskScore reasons = %q, want %q", reasons, tt.wantReasons)
+			}
+			if rating := riskRating(score); rating != tt.wantRating {
+				t.Errorf("riskRating(%d) = %s, want %s", score, rating, tt.wantRating)
+			}
+		})
+	}
+}
+
+func TestRiskRating(t *testing.T) {
+	tests := []struct {
+		score int
+		want  RiskRating
+	}{
+		{score: 0, want: RiskLow},
+		{score: riskScoreMedium, want: RiskMedium},
+		{score: riskScoreHigh - 1, want: RiskMedium},
+		{score: riskScoreHigh, want: RiskHigh},
+		{score: 10, want: RiskHigh},
+	}
+	for _, tt := range tests {
+		if got := riskRating(tt.score); got != tt.want {
+			t.Errorf("riskRating(%d) = %s, want %s", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestCddPolicy(t *testing.T) {
+	base := CddPolicy{
+		Id:          CddThresholds{SingleTrade: decimal.NewFromInt(1000)},
+		Edd:         CddThresholds{SingleTrade: decimal.NewFromInt(10000)},
+		EddValidity: DefaultEddValidity,
+	}
+	high := CddPolicy{
+		Id:  CddThresholds{SingleTrade: decimal.NewFromInt(250)},
+		Edd: CddThresholds{SingleTrade: decimal.NewFromInt(1000), Rolling90: decimal.NewFromInt(5000)},
+	}
+	medium := CddPolicy{
+		Id:          CddThresholds{SingleTrade: decimal.NewFromInt(500)},
+		EddValidity: 180 * 24 * time.Hour,
+	}
+	options := Options{Cdd: base, Risk: RiskPolicy{Cdd: map[RiskRating]CddPolicy{RiskHigh: high, RiskMedium: medium}}}
+	options.Risk.setDefaults(options.Cdd)
+	c := &Core{options: options}
+
+	tests := []struct {
+		rating       RiskRating
+		wantId       decimal.Decimal
+		wantEdd      decimal.Decimal
+		wantValidity time.Duration
+	}{
+		{rating: RiskLow, wantId: decimal.NewFromInt(1000), wantEdd: decimal.NewFromInt(10000), wantValidity: DefaultEddValidity},
+		{rating: RiskMedium, wantId: decimal.NewFromInt(500), wantEdd: decimal.Zero, wantValidity: 180 * 24 * time.Hour},
+		{rating: RiskHigh, wantId: decimal.NewFromInt(250), wantEdd: decimal.NewFromInt(1000), wantValidity: DefaultEddValidity},
+	}
+	for _, tt := range tests {
+		got := c.cddPolicy(tt.rating)
+		if !got.Id.SingleTrade.Equal(tt.wantId) || !got.Edd.SingleTrade.Equal(tt.wantEdd) {
+			t.Errorf("cddPolicy(%s) thresholds = %s ID, %s EDD, want %s, %s",
+				tt.rating, got.Id.SingleTrade, got.Edd.SingleTrade, tt.wantId, tt.wantEdd)
+		}
+		if got.EddValidity != tt.wantValidity {
+			t.Errorf("cddPolicy(%s).EddValidity = %s, want %s", tt.rating, got.EddValidity, tt.wantValidity)
+		}
+	}
+
+	// The defaults are filled in on a copy, not the caller's map.
+	callers := map[RiskRating]CddPolicy{RiskHigh: high}
+	policy := RiskPolicy{Cdd: callers}
+	policy.setDefaults(base)
+	if callers[RiskHigh].EddValidity != 0 {
+		t.Error("setDefaults changed the caller's CDD policies")
+	}
+}
@@ -0,0 +1,839 @@
+package core
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSanctionsMatch      = errors.New("core: customer is a potential sanctions match")
+	ErrInvalidSanctionList = errors.New("core: sanctions list file is not in a supported format")
+	ErrScreeningResolved   = errors.New("core: sanctions match has already been resolved")
+)
+
+// sanctionsPendingReason is the blocked_reason of customers blocked by CreateCustomer for potential sanctions matches.
+// They are unblocked once their last pending match is cleared.
+const sanctionsPendingReason = "Potential sanctions match pending review"
+
+// DefaultSanctionsMatchScore is used when Options.SanctionsMatchScore is not set.
+const DefaultSanctionsMatchScore = 0.75
+
+// Adjustments made to a name similarity score by the tiebreaks, when both sides have the detail.
+const (
+	sanctionsDobMatch        = 0.15
+	sanctionsDobMismatch     = -0.25
+	sanctionsCountryMatch    = 0.05
+	sanctionsCountryMismatch = -0.1
+)
+
+// SanctionsList is one import of a sanctions list file. Only the latest import of each source is screened against.
+type SanctionsList struct {
+	Id         uuid.UUID
+	Source     string // e.g. OFSI or UN
+	FileName   string
+	EntryCount int // Names, including aliases
+	ImportedBy uuid.UUID
+	ImportedAt time.Time
+	IsCurrent  bool
+}
+
+// SanctionsScreening is a potential match between a customer and a sanctions list entry. While it is PENDING the customer
+// can't trade; an operator then clears it as a false positive or confirms it, which blocks the customer.
+type SanctionsScreening struct {
+	Id          uuid.UUID
+	CustomerId  uuid.UUID
+	Source      string
+	ExternalRef string // The entry's reference in the list, shared by its aliases
+	MatchedName string
+	// Score is the trigram similarity of the names, adjusted up or down by whether the date of birth and country agree,
+	// from 0 to 1.
+	Score           float32
+	State           ScreeningState
+	ScreenedAt      time.Time
+	ResolvedBy      uuid.UUID // Nil while PENDING
+	ResolvedAt      time.Time // Zero while PENDING
+	ResolutionNotes string
+}
+
+// SanctionsMatchError is returned when a customer is a potential match for one or more sanctions list entries. It matches
+// ErrSanctionsMatch with errors.Is.
+type SanctionsMatchError struct {
+	CustomerId uuid.UUID
+	Matches    []SanctionsScreening
+}
+
+func (e *SanctionsMatchError) Error() string {
+	return fmt.Sprintf("%s: %d unresolved potential matches for customer %s", ErrSanctionsMatch, len(e.Matches), e.CustomerId)
+}
+
+func (e *SanctionsMatchError) Is(target error) bool {
+	return target == ErrSanctionsMatch
+}
+
+// sanctionsEntry is one name on a sanctions list. Aliases are separate entries with the same ref.
+type sanctionsEntry struct {
+	ref         string
+	name        string
+	dateOfBirth *time.Time
+	dobYear     *int
+	country     *string
+}
+
+// ImportSanctionsList loads a sanctions list file from disk into PG, replacing the previous import from the same source.
+// Customers are screened against it the next time they trade.
+//
+// Files are read by extension. The OFSI consolidated list is read as published, in CSV or XML, as is the UN
+// consolidated list in XML. Their entries are keyed by OFSI group ID or UN reference number; they give countries as
+// names rather than codes, so only dates of birth are used as tiebreaks, and dates of birth that can't be read or that
+// aren't the only one for an entry are left out.
+//
+// Other lists can be converted to a simple format first. A .csv file then needs a header row with a name column, and
+// may have ref, dob and country columns; other columns are ignored. A .xml file holds entry elements with a ref
+// attribute, one or more name elements and optional dob and country elements, under any root element. Dates of birth
+// are YYYY-MM-DD or DD/MM/YYYY, with unknown parts as 00 or just the year; countries are ISO 3166-1 alpha-2 codes.
+func (c *Core) ImportSanctionsList(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	source string,
+	path string,
+) (*SanctionsList, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []sanctionsEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		entries, err = readSanctionsCsv(f)
+	case ".xml":
+		entries, err = readSanctionsXml(f)
+	default:
+		return nil, ErrInvalidSanctionList
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no entries", ErrInvalidSanctionList)
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	list := &SanctionsList{
+		Id:         id,
+		Source:     strings.ToUpper(source),
+		FileName:   filepath.Base(path),
+		EntryCount: len(entries),
+		ImportedBy: op.Id,
+		IsCurrent:  true,
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(
+		ctx,
+		"UPDATE sanctions_lists SET is_current = FALSE WHERE source = $1 AND is_current",
+		list.Source,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(
+		ctx,
+		"INSERT INTO sanctions_lists (id, source, file_name, entry_count, imported_by) VALUES ($1, $2, $3, $4, $5) RETURNING imported_at",
+		list.Id,
+		list.Source,
+		list.FileName,
+		list.EntryCount,
+		list.ImportedBy,
+	).Scan(&list.ImportedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"sanctions_entries"},
+		[]string{"id", "list_id", "external_ref", "name", "name_normalised", "date_of_birth", "dob_year", "country"},
+		pgx.CopyFromSlice(len(entries), func(i int) ([]any, error) {
+			entryId, err := uuid.NewV7()
+			if err != nil {
+				return nil, err
+			}
+			e := entries[i]
+			return []any{entryId, list.Id, e.ref, e.name, normaliseName(e.name), e.dateOfBirth, e.dobYear, e.country}, nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	c.Logger.Info("sanctions list imported", "source", list.Source, "file", list.FileName, "entries", list.EntryCount)
+	return list, nil
+}
+
+// ListSanctionsLists gets every sanctions list import, newest first.
+func (c *Core) ListSanctionsLists(ctx context.Context) ([]SanctionsList, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, source, file_name, entry_count, imported_by, imported_at, is_current FROM sanctions_lists ORDER BY imported_at DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (SanctionsList, error) {
+		var l SanctionsList
+		err := row.Scan(&l.Id, &l.Source, &l.FileName, &l.EntryCount, &l.ImportedBy, &l.ImportedAt, &l.IsCurrent)
+		return l, err
+	})
+}
+
+// ScreenCustomer screens a customer against the current sanctions lists now, returning any new potential matches.
+// Entries already cleared or confirmed for the customer are not raised again.
+func (c *Core) ScreenCustomer(ctx context.Context, customerId uuid.UUID) ([]SanctionsScreening, error) {
+	cust, err := c.GetCustomerById(ctx, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.screenCustomer(ctx, c.pgc, cust)
+}
+
+// screenCustomer matches a customer's name against every current sanctions list entry, scores each candidate with the
+// date of birth and country tiebreaks, and records those scoring at least Options.SanctionsMatchScore as PENDING.
+func (c *Core) screenCustomer(ctx context.Context, db pgQuerier, cust *Customer) ([]SanctionsScreening, error) {
+	name := normaliseName(cust.FullName)
+	rows, err := db.Query(
+		ctx,
+		"SELECT l.source, e.external_ref, e.name, e.date_of_birth, e.dob_year, e.country, similarity(e.name_normalised, $1) FROM sanctions_entries e JOIN sanctions_lists l ON l.id = e.list_id WHERE l.is_current AND e.name_normalised % $1",
+		name,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Aliases share a ref, so keep the best scoring name for each entry.
+	best := map[string]SanctionsScreening{}
+	var e sanctionsEntry
+	var source string
+	var similarity float32
+	if _, err := pgx.ForEachRow(
+		rows,
+		[]any{&source, &e.ref, &e.name, &e.dateOfBirth, &e.dobYear, &e.country, &similarity},
+		func() error {
+			score := c.sanctionsScore(cust, &e, similarity)
+			if score < c.options.SanctionsMatchScore {
+				return nil
+			}
+			key := source + "|" + e.ref
+			if prev, ok := best[key]; ok && prev.Score >= score {
+				return nil
+			}
+			best[key] = SanctionsScreening{
+				CustomerId:  cust.Id,
+				Source:      source,
+				ExternalRef: e.ref,
+				MatchedName: e.name,
+				Score:       score,
+				State:       ScreeningPending,
+			}
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	matches := []SanctionsScreening{}
+	for _, key := range slices.Sorted(maps.Keys(best)) {
+		s := best[key]
+		s.Id, err = uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		err := db.QueryRow(
+			ctx,
+			"INSERT INTO sanctions_screenings (id, customer_id, source, external_ref, matched_name, score) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (customer_id, source, external_ref) DO NOTHING RETURNING screened_at",
+			s.Id,
+			s.CustomerId,
+			s.Source,
+			s.ExternalRef,
+			s.MatchedName,
+			s.Score,
+		).Scan(&s.ScreenedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Already raised for this customer, and either still pending or resolved.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, s)
+	}
+
+	if _, err := db.Exec(ctx, "UPDATE customers SET sanctions_screened_at = NOW() WHERE id = $1", cust.Id); err != nil {
+		return nil, err
+	}
+
+	if len(matches) > 0 {
+		c.Logger.Warn("potential sanctions match", "customer_id", cust.Id, "matches", len(matches))
+	}
+	return matches, nil
+}
+
+// sanctionsScore adjusts a name similarity by whether the customer's date of birth and nationality agree with an entry's.
+// Details missing from either side leave the score alone.
+func (c *Core) sanctionsScore(cust *Customer, e *sanctionsEntry, similarity float32) float32 {
+	score := similarity
+	if !cust.DateOfBirth.IsZero() {
+		switch {
+		case e.dateOfBirth != nil:
+			if cust.DateOfBirth.Format(time.DateOnly) == e.dateOfBirth.Format(time.DateOnly) {
+				score += sanctionsDobMatch
+			} else {
+				score += sanctionsDobMismatch
+			}
+		case e.dobYear != nil:
+			if cust.DateOfBirth.Year() == *e.dobYear {
+				score += sanctionsDobMatch
+			} else {
+				score += sanctionsDobMismatch
+			}
+		}
+	}
+	if cust.Nationality != "" && e.country != nil {
+		if strings.EqualFold(cust.Nationality, *e.country) {
+			score += sanctionsCountryMatch
+		} else {
+			score += sanctionsCountryMismatch
+		}
+	}
+	return min(max(score, 0), 1)
+}
+
+// ResolveSanctionsScreening records an operator's decision on a potential match. A confirmed match blocks the customer; a
+// cleared one lets them trade again once no others are pending, unblocking them if CreateCustomer blocked them for it.
+func (c *Core) ResolveSanctionsScreening(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	screeningId uuid.UUID,
+	confirmed bool,
+	notes string,
+) error {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return err
+	}
+	if !op.IsActive {
+		return ErrOperatorInactive
+	}
+
+	state := ScreeningCleared
+	if confirmed {
+		state = ScreeningConfirmed
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var customerId uuid.UUID
+	var source, ref string
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE sanctions_screenings SET state = $1, resolved_by = $2, resolved_at = NOW(), resolution_notes = $3 WHERE id = $4 AND state = $5 RETURNING customer_id, source, external_ref",
+		state,
+		op.Id,
+		notes,
+		screeningId,
+		ScreeningPending,
+	).Scan(&customerId, &source, &ref)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := c.getSanctionsScreening(ctx, screeningId); err != nil {
+			return err
+		}
+		return ErrScreeningResolved
+	}
+	if err != nil {
+		return err
+	}
+	if confirmed {
+		if _, err := tx.Exec(
+			ctx,
+			"UPDATE customers SET is_blocked = TRUE, blocked_reason = $1 WHERE id = $2",
+			fmt.Sprintf("Confirmed sanctions match on %s list entry %s", source, ref),
+			customerId,
+		); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(
+		ctx,
+		"UPDATE customers c SET is_blocked = FALSE, blocked_reason = NULL WHERE c.id = $1 AND c.blocked_reason = $2 AND NOT EXISTS (SELECT 1 FROM sanctions_screenings s WHERE s.customer_id = c.id AND s.state = $3)",
+		customerId,
+		sanctionsPendingReason,
+		ScreeningPending,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	c.Logger.Info(
+		"sanctions screening resolved",
+		"screening_id",
+		screeningId,
+		"customer_id",
+		customerId,
+		"state",
+		state,
+		"operator_id",
+		op.Id,
+	)
+	return nil
+}
+
+// ListSanctionsScreenings gets the potential matches raised for a customer, or for every customer if customerId is Nil,
+// newest first. An empty state lists screenings in any state.
+func (c *Core) ListSanctionsScreenings(
+	ctx context.Context,
+	customerId uuid.UUID,
+	state ScreeningState,
+) ([]SanctionsScreening, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		sqlSelectSanctionsScreenings+" WHERE ($1::UUID IS NULL OR customer_id = $1) AND ($2::TEXT = '' OR state = $2) ORDER BY screened_at DESC",
+		nullableId(customerId),
+		state,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (SanctionsScreening, error) {
+		s, err := scanSanctionsScreening(row)
+		if err != nil {
+			return SanctionsScreening{}, err
+		}
+		return *s, nil
+	})
+}
+
+func (c *Core) getSanctionsScreening(ctx context.Context, id uuid.UUID) (*SanctionsScreening, error) {
+	return scanSanctionsScreening(c.pgc.QueryRow(ctx, sqlSelectSanctionsScreenings+" WHERE id = $1", id))
+}
+
+// checkSanctions screens a customer again if a list has been imported since they were last screened, then returns a
+// *SanctionsMatchError if any of their potential matches are still pending. Confirmed matches block the customer, so they
+// are refused before this is reached.
+func (c *Core) checkSanctions(ctx context.Context, cust *Customer) error {
+	var stale bool
+	if err := c.pgc.QueryRow(
+		ctx,
+		"SELECT COALESCE(c.sanctions_screened_at < (SELECT MAX(imported_at) FROM sanctions_lists WHERE is_current), c.sanctions_screened_at IS NULL) FROM customers c WHERE c.id = $1",
+		cust.Id,
+	).Scan(&stale); err != nil {
+		return err
+	}
+	if stale {
+		if _, err := c.screenCustomer(ctx, c.pgc, cust); err != nil {
+			return err
+		}
+	}
+
+	pending, err := c.ListSanctionsScreenings(ctx, cust.Id, ScreeningPending)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return &SanctionsMatchError{CustomerId: cust.Id, Matches: pending}
+	}
+	return nil
+}
+
+const sqlSelectSanctionsScreenings = "SELECT id, customer_id, source, external_ref, matched_name, score, state, screened_at, resolved_by, resolved_at, COALESCE(resolution_notes, '') FROM sanctions_screenings"
+
+// scanSanctionsScreening scans a row selected with sqlSelectSanctionsScreenings.
+func scanSanctionsScreening(row pgx.Row) (*SanctionsScreening, error) {
+	s := &SanctionsScreening{}
+	var resolvedBy *uuid.UUID
+	var resolvedAt *time.Time
+	if err := row.Scan(
+		&s.Id,
+		&s.CustomerId,
+		&s.Source,
+		&s.ExternalRef,
+		&s.MatchedName,
+		&s.Score,
+		&s.State,
+		&s.ScreenedAt,
+		&resolvedBy,
+		&resolvedAt,
+		&s.ResolutionNotes,
+	); err != nil {
+		return nil, err
+	}
+
+	if resolvedBy != nil {
+		s.ResolvedBy = *resolvedBy
+	}
+	if resolvedAt != nil {
+		s.ResolvedAt = *resolvedAt
+	}
+	return s, nil
+}
+
+// normaliseName lower-cases a name, drops punctuation and sorts its words, so that e.g. "SMITH, John" and "John Smith"
+// compare as equal.
+func normaliseName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slices.Sort(words)
+	return strings.Join(words, " ")
+}
+
+// readSanctionsCsv reads entries from a CSV sanctions list; see ImportSanctionsList.
+func readSanctionsCsv(r io.Reader) ([]sanctionsEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	// The OFSI list starts with a "Last Updated" line before its header.
+	line := 1
+	header, err := cr.Read()
+	if err == nil && len(header) > 0 && strings.EqualFold(strings.TrimSpace(header[0]), "last updated") {
+		line++
+		header, err = cr.Read()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	field := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	if _, ok := columns["name 6"]; ok {
+		return readOfsiCsv(cr, line, field)
+	}
+	nameCol, ok := columns["name"]
+	if !ok {
+		return nil, fmt.Errorf("%w: no name column", ErrInvalidSanctionList)
+	}
+
+	entries := []sanctionsEntry{}
+	for line++; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+		}
+		if nameCol >= len(record) || strings.TrimSpace(record[nameCol]) == "" {
+			continue
+		}
+
+		ref := field(record, "ref")
+		if ref == "" {
+			ref = "line-" + strconv.Itoa(line)
+		}
+		entry, err := newSanctionsEntry(ref, record[nameCol], field(record, "dob"), field(record, "country"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidSanctionList, line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// readOfsiCsv reads the rows of the OFSI consolidated list in CSV after its header, which ended on line. Each row is
+// one name for a group, with the surname in Name 6.
+func readOfsiCsv(cr *csv.Reader, line int, field func(record []string, column string) string) ([]sanctionsEntry, error) {
+	entries := []sanctionsEntry{}
+	for line++; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+		}
+
+		var names [6]string
+		for i := range names {
+			names[i] = field(record, "name "+strconv.Itoa(i+1))
+		}
+		ref := field(record, "group id")
+		if ref == "" {
+			ref = "line-" + strconv.Itoa(line)
+		}
+		if entry, ok := newOfficialSanctionsEntry(ref, ofsiName(names), field(record, "dob")); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// ofsiName joins the parts of an OFSI name that are set: given names in Name 1 to 5, then the surname or entity name in
+// Name 6.
+func ofsiName(names [6]string) string {
+	parts := slices.DeleteFunc(names[:], func(part string) bool {
+		return strings.TrimSpace(part) == ""
+	})
+	return strings.Join(parts, " ")
+}
+
+// readSanctionsXml reads entries from an XML sanctions list; see ImportSanctionsList. The OFSI and UN lists are told
+// apart by their root element.
+func readSanctionsXml(r io.Reader) ([]sanctionsEntry, error) {
+	d := xml.NewDecoder(r)
+	var root xml.StartElement
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+	switch root.Name.Local {
+	case "ArrayOfFinancialSanctionsTarget":
+		return readOfsiXml(d, &root)
+	case "CONSOLIDATED_LIST":
+		return readUnXml(d, &root)
+	}
+
+	var list struct {
+		Entries []struct {
+			Ref         string   `xml:"ref,attr"`
+			Names       []string `xml:"name"`
+			DateOfBirth string   `xml:"dob"`
+			Country     string   `xml:"country"`
+		} `xml:"entry"`
+	}
+	if err := d.DecodeElement(&list, &root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+	}
+
+	entries := []sanctionsEntry{}
+	for i, x := range list.Entries {
+		ref := strings.TrimSpace(x.Ref)
+		if ref == "" {
+			ref = "entry-" + strconv.Itoa(i+1)
+		}
+		for _, name := range x.Names {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			entry, err := newSanctionsEntry(ref, name, strings.TrimSpace(x.DateOfBirth), strings.TrimSpace(x.Country))
+			if err != nil {
+				return nil, fmt.Errorf("%w: entry %s: %w", ErrInvalidSanctionList, ref, err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// readOfsiXml reads the OFSI consolidated list in XML, whose FinancialSanctionsTarget elements are laid out like the rows
+// of its CSV.
+func readOfsiXml(d *xml.Decoder, root *xml.StartElement) ([]sanctionsEntry, error) {
+	var list struct {
+		Targets []struct {
+			Name1   string `xml:"Name1"`
+			Name2   string `xml:"Name2"`
+			Name3   string `xml:"Name3"`
+			Name4   string `xml:"Name4"`
+			Name5   string `xml:"Name5"`
+			Name6   string `xml:"Name6"`
+			DOB     string `xml:"DOB"`
+			GroupID string `xml:"GroupID"`
+		} `xml:"FinancialSanctionsTarget"`
+	}
+	if err := d.DecodeElement(&list, root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+	}
+
+	entries := []sanctionsEntry{}
+	for i, x := range list.Targets {
+		ref := strings.TrimSpace(x.GroupID)
+		if ref == "" {
+			ref = "entry-" + strconv.Itoa(i+1)
+		}
+		name := ofsiName([6]string{x.Name1, x.Name2, x.Name3, x.Name4, x.Name5, x.Name6})
+		if entry, ok := newOfficialSanctionsEntry(ref, name, x.DOB); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// unDateOfBirth is an INDIVIDUAL_DATE_OF_BIRTH in the UN list, which holds either a full DATE or just a YEAR.
+type unDateOfBirth struct {
+	Date string `xml:"DATE"`
+	Year string `xml:"YEAR"`
+}
+
+// readUnXml reads the UN consolidated list in XML: its individuals and entities, each under their names and aliases.
+func readUnXml(d *xml.Decoder, root *xml.StartElement) ([]sanctionsEntry, error) {
+	var list struct {
+		Individuals []struct {
+			Ref          string          `xml:"REFERENCE_NUMBER"`
+			FirstName    string          `xml:"FIRST_NAME"`
+			SecondName   string          `xml:"SECOND_NAME"`
+			ThirdName    string          `xml:"THIRD_NAME"`
+			FourthName   string          `xml:"FOURTH_NAME"`
+			Aliases      []string        `xml:"INDIVIDUAL_ALIAS>ALIAS_NAME"`
+			DatesOfBirth []unDateOfBirth `xml:"INDIVIDUAL_DATE_OF_BIRTH"`
+		} `xml:"INDIVIDUALS>INDIVIDUAL"`
+		Entities []struct {
+			Ref     string   `xml:"REFERENCE_NUMBER"`
+			Name    string   `xml:"FIRST_NAME"`
+			Aliases []string `xml:"ENTITY_ALIAS>ALIAS_NAME"`
+		} `xml:"ENTITIES>ENTITY"`
+	}
+	if err := d.DecodeElement(&list, root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSanctionList, err)
+	}
+
+	entries := []sanctionsEntry{}
+	add := func(ref string, names []string, dob string) {
+		for _, name := range names {
+			if entry, ok := newOfficialSanctionsEntry(ref, name, dob); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	for i, x := range list.Individuals {
+		ref := strings.TrimSpace(x.Ref)
+		if ref == "" {
+			ref = "individual-" + strconv.Itoa(i+1)
+		}
+
+		// Only use the date of birth if there is just one, so the others don't count against a true match.
+		dob := ""
+		if len(x.DatesOfBirth) == 1 {
+			dob = strings.TrimSpace(x.DatesOfBirth[0].Date)
+			if before, _, ok := strings.Cut(dob, "T"); ok {
+				dob = before
+			}
+			if dob == "" {
+				dob = strings.TrimSpace(x.DatesOfBirth[0].Year)
+			}
+		}
+
+		name := strings.Join([]string{x.FirstName, x.SecondName, x.ThirdName, x.FourthName}, " ")
+		add(ref, append([]string{name}, x.Aliases...), dob)
+	}
+	for i, x := range list.Entities {
+		ref := strings.TrimSpace(x.Ref)
+		if ref == "" {
+			ref = "entity-" + strconv.Itoa(i+1)
+		}
+		add(ref, append([]string{x.Name}, x.Aliases...), "")
+	}
+
+	return entries, nil
+}
+
+// newOfficialSanctionsEntry makes an entry from an official list, leaving out a date of birth that can't be read rather
+// than failing the import. It returns false if the name is empty.
+func newOfficialSanctionsEntry(ref string, name string, dob string) (sanctionsEntry, bool) {
+	if strings.TrimSpace(name) == "" {
+		return sanctionsEntry{}, false
+	}
+	entry, err := newSanctionsEntry(ref, name, strings.TrimSpace(dob), "")
+	if err != nil {
+		entry, _ = newSanctionsEntry(ref, name, "", "")
+	}
+	return entry, true
+}
+
+func newSanctionsEntry(ref string, name string, dob string, country string) (sanctionsEntry, error) {
+	e := sanctionsEntry{ref: ref, name: strings.Join(strings.Fields(name), " ")}
+	if country != "" {
+		country = strings.ToUpper(country)
+		e.country = &country
+	}
+
+	if dob == "" {
+		return e, nil
+	}
+	var day, month, year string
+	switch {
+	case len(dob) == 4:
+		year = dob
+	case strings.Count(dob, "-") == 2:
+		parts := strings.Split(dob, "-")
+		year, month, day = parts[0], parts[1], parts[2]
+	case strings.Count(dob, "/") == 2:
+		parts := strings.Split(dob, "/")
+		day, month, year = parts[0], parts[1], parts[2]
+	default:
+		return e, fmt.Errorf("unrecognised date of birth %q", dob)
+	}
+
+	y, err := strconv.Atoi(year)
+	if err != nil || y == 0 {
+		return e, fmt.Errorf("unrecognised date of birth %q", dob)
+	}
+	m, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	if m == 0 || d == 0 {
+		e.dobYear = &y
+		return e, nil
+	}
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if t.Month() != time.Month(m) || t.Day() != d {
+		return e, fmt.Errorf("unrecognised date of birth %q", dob)
+	}
+	e.dateOfBirth = &t
+	return e, nil
+}
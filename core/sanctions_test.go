@@ -0,0 +1,325 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// describeEntries formats entries as "ref|name|dob|country" for comparing in tests, with the date of birth as a date, a
+// year or empty.
+func describeEntries(entries []sanctionsEntry) []string {
+	described := make([]string, len(entries))
+	for i, e := range entries {
+		dob := ""
+		if e.dateOfBirth != nil {
+			dob = e.dateOfBirth.Format(time.DateOnly)
+		} else if e.dobYear != nil {
+			dob = strconv.Itoa(*e.dobYear)
+		}
+		country := ""
+		if e.country != nil {
+			country = *e.country
+		}
+		described[i] = fmt.Sprintf("%s|%s|%s|%s", e.ref, e.name, dob, country)
+	}
+	return described
+}
+
+func TestOfsiName(t *testing.T) {
+	tests := []struct {
+		names [6]string
+		want  string
+	}{
+		{names: [6]string{"John", "Paul", "", "", "", "SMITH"}, want: "John Paul SMITH"},
+		{names: [6]string{"", "", "", "", "", "ACME TRADING LLC"}, want: "ACME TRADING LLC"},
+		{names: [6]string{"Ali", "", "bin", " ", "", "Hassan"}, want: "Ali bin Hassan"},
+		{names: [6]string{"A", "B", "C", "D", "E", "F"}, want: "A B C D E F"},
+		{names: [6]string{}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := ofsiName(tt.names); got != tt.want {
+			t.Errorf("ofsiName(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestNormaliseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "John Smith", want: "john smith"},
+		{name: "SMITH, John", want: "john smith"},
+		{name: "  O'Brien-Jones,  Mary ", want: "brien jones mary o"},
+		{name: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normaliseName(tt.name); got != tt.want {
+			t.Errorf("normaliseName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewSanctionsEntry(t *testing.T) {
+	tests := []struct {
+		dob     string
+		country string
+		want    string
+		wantErr bool
+	}{
+		{dob: "", want: "R1|John Smith||"},
+		{dob: "1970-03-25", country: "gb", want: "R1|John Smith|1970-03-25|GB"},
+		{dob: "25/03/1970", want: "R1|John Smith|1970-03-25|"},
+		{dob: "1970", want: "R1|John Smith|1970|"},
+		{dob: "00/00/1970", want: "R1|John Smith|1970|"},
+		{dob: "1970-00-00", want: "R1|John Smith|1970|"},
+		{dob: "31/02/1970", wantErr: true},
+		{dob: "1970-13-01", wantErr: true},
+		{dob: "00/00/0000", wantErr: true},
+		{dob: "March 1970", wantErr: true},
+	}
+	for _, tt := range tests {
+		entry, err := newSanctionsEntry("R1", "  John   Smith ", tt.dob, tt.country)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newSanctionsEntry with dob %q: want an error", tt.dob)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newSanctionsEntry with dob %q: %v", tt.dob, err)
+			continue
+		}
+		if got := describeEntries([]sanctionsEntry{entry})[0]; got != tt.want {
+			t.Errorf("newSanctionsEntry with dob %q = %q, want %q", tt.dob, got, tt.want)
+		}
+	}
+
+	// Official lists keep the name when the date of birth can't be read.
+	entry, ok := newOfficialSanctionsEntry("R2", "Jane Doe", "circa 1960")
+	if !ok || describeEntries([]sanctionsEntry{entry})[0] != "R2|Jane Doe||" {
+		t.Errorf("newOfficialSanctionsEntry with an unreadable dob = %v, %t", describeEntries([]sanctionsEntry{entry}), ok)
+	}
+	if _, ok := newOfficialSanctionsEntry("R3", "  ", ""); ok {
+		t.Error("newOfficialSanctionsEntry with an empty name: want false")
+	}
+}
+
+func TestReadSanctionsCsv(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "simple",
+			csv: "name,dob,country,ref\n" +
+				"John Smith,1970-03-25,gb,A1\n" +
+				"Jane Doe,,,\n" +
+				",,,A3\n",
+			want: []string{"A1|John Smith|1970-03-25|GB", "line-3|Jane Doe||"},
+		},
+		{
+			name:    "simple with a bad date of birth",
+			csv:     "name,dob\nJohn Smith,circa 1970\n",
+			wantErr: true,
+		},
+		{
+			name:    "no name column",
+			csv:     "ref,dob\nA1,1970\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			csv:     "",
+			wantErr: true,
+		},
+		{
+			name: "OFSI",
+			csv: "Last Updated,01/10/2026\n" +
+				"Name 6,Name 1,Name 2,Name 3,Name 4,Name 5,Title,DOB,Group ID\n" +
+				"SMITH,John,Paul,,,,Mr,25/03/1970,1001\n" +
+				"SMYTH,John,,,,,,dd/mm/1970,1001\n" +
+				"ACME TRADING LLC,,,,,,,,1002\n" +
+				",,,,,,,,1003\n",
+			want: []string{
+				"1001|John Paul SMITH|1970-03-25|",
+				"1001|John SMYTH|1970|",
+				"1002|ACME TRADING LLC||",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readSanctionsCsv(strings.NewReader(tt.csv))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSanctionList) {
+					t.Fatalf("readSanctionsCsv error = %v, want ErrInvalidSanctionList", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSanctionsCsv error = %v", err)
+			}
+			if got := describeEntries(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("readSanctionsCsv = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSanctionsXml(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "simple",
+			xml: `<list>
+  <entry ref="A1"><name>John Smith</name><name>Johnny Smith</name><dob>1970-03-25</dob><country>gb</country></entry>
+  <entry><name>Jane Doe</name><name> </name></entry>
+</list>`,
+			want: []string{"A1|John Smith|1970-03-25|GB", "A1|Johnny Smith|1970-03-25|GB", "entry-2|Jane Doe||"},
+		},
+		{
+			name:    "simple with a bad date of birth",
+			xml:     `<list><entry ref="A1"><name>John Smith</name><dob>circa 1970</dob></entry></list>`,
+			wantErr: true,
+		},
+		{
+			name: "OFSI",
+			xml: `<?xml version="1.0" encoding="utf-8"?>
+<ArrayOfFinancialSanctionsTarget xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns="http://schemas.hmtreasury.gov.uk/ofsi/consolidatedlist">
+  <FinancialSanctionsTarget>
+    <Name6>SMITH</Name6><Name1>John</Name1><Name2>Paul</Name2><Name3 /><DOB>25/03/1970</DOB><GroupID>1001</GroupID>
+  </FinancialSanctionsTarget>
+  <FinancialSanctionsTarget>
+    <Name6>ACME TRADING LLC</Name6><DOB>unknown</DOB><GroupID>1002</GroupID>
+  </FinancialSanctionsTarget>
+  <FinancialSanctionsTarget><Name6></Name6></FinancialSanctionsTarget>
+  <FinancialSanctionsTarget><Name6>NO GROUP</Name6></FinancialSanctionsTarget>
+</ArrayOfFinancialSanctionsTarget>`,
+			want: []string{"1001|John Paul SMITH|1970-03-25|", "1002|ACME TRADING LLC||", "entry-4|NO GROUP||"},
+		},
+		{
+			name: "UN",
+			xml: `<?xml version="1.0" encoding="UTF-8"?>
+<CONSOLIDATED_LIST dateGenerated="2026-10-01T00:00:00Z">
+  <INDIVIDUALS>
+    <INDIVIDUAL>
+      <REFERENCE_NUMBER>QDi.001</REFERENCE_NUMBER>
+      <FIRST_NAME>AHMED</FIRST_NAME><SECOND_NAME>ALI</SECOND_NAME><THIRD_NAME></THIRD_NAME>
+      <INDIVIDUAL_ALIAS><QUALITY>Good</QUALITY><ALIAS_NAME>Abu Ahmed</ALIAS_NAME></INDIVIDUAL_ALIAS>
+      <INDIVIDUAL_ALIAS><QUALITY>Low</QUALITY><ALIAS_NAME>Ahmad Ali</ALIAS_NAME></INDIVIDUAL_ALIAS>
+      <INDIVIDUAL_DATE_OF_BIRTH><TYPE_OF_DATE>EXACT</TYPE_OF_DATE><DATE>1965-01-02T00:00:00</DATE></INDIVIDUAL_DATE_OF_BIRTH>
+    </INDIVIDUAL>
+    <INDIVIDUAL>
+      <REFERENCE_NUMBER>QDi.002</REFERENCE_NUMBER>
+      <FIRST_NAME>OMAR</FIRST_NAME>
+      <INDIVIDUAL_DATE_OF_BIRTH><TYPE_OF_DATE>EXACT</TYPE_OF_DATE><YEAR>1970</YEAR></INDIVIDUAL_DATE_OF_BIRTH>
+    </INDIVIDUAL>
+    <INDIVIDUAL>
+      <REFERENCE_NUMBER>QDi.003</REFERENCE_NUMBER>
+      <FIRST_NAME>HASSAN</FIRST_NAME>
+      <INDIVIDUAL_DATE_OF_BIRTH><YEAR>1960</YEAR></INDIVIDUAL_DATE_OF_BIRTH>
+      <INDIVIDUAL_DATE_OF_BIRTH><YEAR>1961</YEAR></INDIVIDUAL_DATE_OF_BIRTH>
+    </INDIVIDUAL>
+  </INDIVIDUALS>
+  <ENTITIES>
+    <ENTITY>
+      <REFERENCE_NUMBER>QDe.001</REFERENCE_NUMBER>
+      <FIRST_NAME>ACME FRONT COMPANY</FIRST_NAME>
+      <ENTITY_ALIAS><ALIAS_NAME>ACME FC</ALIAS_NAME></ENTITY_ALIAS>
+    </ENTITY>
+  </ENTITIES>
+</CONSOLIDATED_LIST>`,
+			want: []string{
+				"QDi.001|AHMED ALI|1965-01-02|",
+				"QDi.001|Abu Ahmed|1965-01-02|",
+				"QDi.001|Ahmad Ali|1965-01-02|",
+				"QDi.002|OMAR|1970|",
+				"QDi.003|HASSAN||",
+				"QDe.001|ACME FRONT COMPANY||",
+				"QDe.001|ACME FC||",
+			},
+		},
+		{
+			name:    "not XML",
+			xml:     "name,dob\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readSanctionsXml(strings.NewReader(tt.xml))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSanctionList) {
+					t.Fatalf("readSanctionsXml error = %v, want ErrInvalidSanctionList", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSanctionsXml error = %v", err)
+			}
+			if got := describeEntries(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("readSanctionsXml = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanctionsScore(t *testing.T) {
+	dob := time.Date(1970, 3, 25, 0, 0, 0, 0, time.UTC)
+	year := func(y int) *int { return &y }
+	country := func(c string) *string { return &c }
+	otherDob := time.Date(1971, 3, 25, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		similarity float32 // 0.7 if not set
+		cust       Customer
+		entry      sanctionsEntry
+		want       float32
+	}{
+		{name: "no details", cust: Customer{}, entry: sanctionsEntry{dateOfBirth: &dob, country: country("GB")}, want: 0.7},
+		{name: "dob matches", cust: Customer{DateOfBirth: dob}, entry: sanctionsEntry{dateOfBirth: &dob}, want: 0.85},
+		{name: "dob differs", cust: Customer{DateOfBirth: dob}, entry: sanctionsEntry{dateOfBirth: &otherDob}, want: 0.45},
+		{name: "year matches", cust: Customer{DateOfBirth: dob}, entry: sanctionsEntry{dobYear: year(1970)}, want: 0.85},
+		{name: "year differs", cust: Customer{DateOfBirth: dob}, entry: sanctionsEntry{dobYear: year(1980)}, want: 0.45},
+		{name: "entry has no dob", cust: Customer{DateOfBirth: dob}, entry: sanctionsEntry{}, want: 0.7},
+		{name: "country matches", cust: Customer{Nationality: "gb"}, entry: sanctionsEntry{country: country("GB")}, want: 0.75},
+		{name: "country differs", cust: Customer{Nationality: "FR"}, entry: sanctionsEntry{country: country("GB")}, want: 0.6},
+		{
+			name:       "capped at 1",
+			similarity: 0.95,
+			cust:       Customer{DateOfBirth: dob, Nationality: "GB"},
+			entry:      sanctionsEntry{dateOfBirth: &dob, country: country("GB")},
+			want:       1,
+		},
+		{
+			name:       "floored at 0",
+			similarity: 0.1,
+			cust:       Customer{DateOfBirth: dob, Nationality: "FR"},
+			entry:      sanctionsEntry{dateOfBirth: &otherDob, country: country("GB")},
+			want:       0,
+		},
+	}
+	c := &Core{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.sanctionsScore(&tt.cust, &tt.entry, cmp.Or(tt.similarity, 0.7))
+			if diff := got - tt.want; diff > 1e-6 || diff < -1e-6 {
+				t.Errorf("sanctionsScore = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
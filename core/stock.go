@@ -0,0 +1,596 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrInvalidStockRoute    = errors.New("core: stock must move between two different branches, or between a branch and a supplier")
+	ErrStockNotInTransit    = errors.New("core: stock transfer has already been received or voided")
+	ErrConsignmentRequired  = errors.New("core: consignment reference is required")
+	ErrOperatorNotAtBranch  = errors.New("core: operator does not belong to the branch")
+	ErrInvalidExpectedValue = errors.New("core: expected value must not be negative")
+)
+
+// StockTransfer is a consignment of one currency moving between two branches, or between a branch and a wholesale
+// banknote supplier.
+//
+// Dispatch creates a pending TB transfer out of the sender's liquidity account (or wholesale account, for stock bought from a
+// supplier) into an in-transit account, so the stock can't be traded while it is on the road. Receipt posts it and moves the
+// stock on into the receiver's liquidity account (or wholesale account, for stock returned to a supplier). Voiding releases
+// it back to the sender. Stock from a supplier is held in the receiving branch's in-transit account; otherwise the sender's.
+// Settling with the supplier is outside HyperFX.
+type StockTransfer struct {
+	TbPendingId    tbTypes.Uint128
+	TbReceiptId    tbTypes.Uint128 // Zero until received
+	FromBranchId   uuid.UUID       // Nil if bought from a supplier
+	ToBranchId     uuid.UUID       // Nil if returned to a supplier
+	Supplier       string          // Empty unless one side is a supplier
+	Ledger         Ledger
+	Amount         uint64          // In the ledger's minor unit
+	ExpectedValue  decimal.Decimal // In the local currency's display units, e.g. for insurance or the supplier's invoice
+	ConsignmentRef string
+	CourierRef     string
+	State          StockTransferState
+	DispatchedBy   uuid.UUID
+	DispatchedAt   time.Time
+	ResolvedBy     uuid.UUID // Nil while in transit
+	ResolvedAt     time.Time // Zero while in transit
+}
+
+type DispatchStockData struct {
+	OperatorId     uuid.UUID
+	FromBranchId   uuid.UUID // Nil if bought from a supplier
+	ToBranchId     uuid.UUID // Nil if returned to a supplier
+	Supplier       string    // Required if either branch is Nil
+	Ledger         Ledger
+	Amount         decimal.Decimal // In display units
+	ExpectedValue  decimal.Decimal
+	ConsignmentRef string
+	CourierRef     string
+}
+
+// DispatchStock records a consignment leaving the sender. The operator must belong to the sending branch, or to the
+// receiving branch when ordering from a supplier, and that branch must have the ledger open.
+func (c *Core) DispatchStock(ctx context.Context, data DispatchStockData) (*StockTransfer, error) {
+	fromSupplier, toSupplier := data.FromBranchId == uuid.Nil, data.ToBranchId == uuid.Nil
+	if fromSupplier == toSupplier && (fromSupplier || data.FromBranchId == data.ToBranchId) {
+		return nil, ErrInvalidStockRoute
+	}
+	if (fromSupplier || toSupplier) == (data.Supplier == "") {
+		return nil, ErrInvalidStockRoute
+	}
+	if data.ConsignmentRef == "" {
+		return nil, ErrConsignmentRequired
+	}
+	if _, ok := CurrencyAssetScales[data.Ledger]; !ok {
+		return nil, ErrInvalidLedger
+	}
+	if !validAmount(data.Amount, data.Ledger) {
+		return nil, ErrInvalidAmount
+	}
+	amount, err := toMinorUnits(data.Amount, data.Ledger)
+	if err != nil {
+		return nil, err
+	}
+	if data.ExpectedValue.IsNegative() {
+		return nil, ErrInvalidExpectedValue
+	}
+
+	// The dispatching branch is the one that gives up the stock, or orders it from a supplier.
+	branchId := data.FromBranchId
+	if fromSupplier {
+		branchId = data.ToBranchId
+	}
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if op.BranchId != branchId {
+		return nil, ErrOperatorNotAtBranch
+	}
+	for _, id := range []uuid.UUID{data.FromBranchId, data.ToBranchId} {
+		if id == uuid.Nil {
+			continue
+		}
+		if err := c.checkBranchActive(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkLedgersTrading(ctx, c.pgc, branchId, data.Ledger); err != nil {
+		return nil, err
+	}
+	ids, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &StockTransfer{
+		TbPendingId:    tbTypes.ID(),
+		FromBranchId:   data.FromBranchId,
+		ToBranchId:     data.ToBranchId,
+		Supplier:       data.Supplier,
+		Ledger:         data.Ledger,
+		Amount:         amount,
+		ExpectedValue:  data.ExpectedValue,
+		ConsignmentRef: data.ConsignmentRef,
+		CourierRef:     data.CourierRef,
+		State:          StockInTransit,
+		DispatchedBy:   data.OperatorId,
+	}
+	// Stock ordered from a supplier comes out of the wholesale account, so only stock leaving the safe is checked.
+	source := ids.liquidity[data.Ledger]
+	if fromSupplier {
+		source = ids.wholesale[data.Ledger]
+	}
+	paidOut := tradeSide{ledger: data.Ledger, amount: amount, liquidity: source}
+	if !fromSupplier {
+		if err := c.checkAvailableStock(paidOut.ledger, paidOut.liquidity, paidOut.amount); err != nil {
+			return nil, err
+		}
+	}
+	transfer := tbTypes.Transfer{
+		ID:              st.TbPendingId,
+		DebitAccountID:  ids.inTransit[data.Ledger],
+		CreditAccountID: source,
+		Amount:          tbTypes.ToUint128(st.Amount),
+		Ledger:          uint32(data.Ledger),
+		Code:            uint16(TransferCodeStock),
+		Flags: tbTypes.TransferFlags{
+			Pending: true,
+		}.ToUint16(),
+	}
+	if err := c.createTransfers([]tbTypes.Transfer{transfer}); err != nil {
+		return nil, c.stockError(err, paidOut)
+	}
+
+	if err := c.pgc.QueryRow(
+		ctx,
+		"INSERT INTO stock_transfers (tb_pending_id, from_branch_id, to_branch_id, supplier, ledger_id, amount, expected_value, consignment_ref, courier_ref, dispatched_by) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), $10) RETURNING dispatched_at",
+		tbToUuid(st.TbPendingId),
+		nullableId(st.FromBranchId),
+		nullableId(st.ToBranchId),
+		st.Supplier,
+		st.Ledger,
+		st.Amount,
+		st.ExpectedValue,
+		st.ConsignmentRef,
+		st.CourierRef,
+		st.DispatchedBy,
+	).Scan(&st.DispatchedAt); err != nil {
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfer)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending stock transfer after PG insert failed",
+				"tb_pending_id",
+				st.TbPendingId,
+				"error",
+				voidErr,
+			)
+		}
+		return nil, err
+	}
+
+	c.Logger.Info(
+		"stock dispatched",
+		"tb_pending_id",
+		st.TbPendingId,
+		"from_branch_id",
+		st.FromBranchId,
+		"to_branch_id",
+		st.ToBranchId,
+		"ledger",
+		st.Ledger,
+		"amount",
+		st.Amount,
+		"consignment_ref",
+		st.ConsignmentRef,
+	)
+	return st, nil
+}
+
+// ReceiveStock posts a consignment on arrival and moves it into the receiver. The operator must belong to the receiving
+// branch, which must have the ledger open, or to the sending branch when confirming a return to a supplier.
+func (c *Core) ReceiveStock(ctx context.Context, operatorId uuid.UUID, tbPendingId tbTypes.Uint128) (*StockTransfer, error) {
+	return c.resolveStockTransfer(ctx, operatorId, tbPendingId, false)
+}
+
+// VoidStockTransfer releases a consignment back to the sender, e.g. if it was never collected. The operator may belong to
+// either branch.
+func (c *Core) VoidStockTransfer(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	tbPendingId tbTypes.Uint128,
+) (*StockTransfer, error) {
+	return c.resolveStockTransfer(ctx, operatorId, tbPendingId, true)
+}
+
+func (c *Core) resolveStockTransfer(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	tbPendingId tbTypes.Uint128,
+	void bool,
+) (*StockTransfer, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	st, err := scanStockTransfer(tx.QueryRow(ctx, sqlSelectStockTransfers+" WHERE tb_pending_id = $1 FOR UPDATE", tbToUuid(tbPendingId)))
+	if err != nil {
+		return nil, err
+	}
+	if st.State != StockInTransit {
+		return nil, ErrStockNotInTransit
+	}
+
+	// Returns to a supplier are confirmed by the sender, since there is no receiving branch.
+	receiverId := st.ToBranchId
+	if receiverId == uuid.Nil {
+		receiverId = st.FromBranchId
+	}
+	switch {
+	case void && op.BranchId != st.FromBranchId && op.BranchId != st.ToBranchId:
+		return nil, ErrOperatorNotAtBranch
+	case !void && op.BranchId != receiverId:
+		return nil, ErrOperatorNotAtBranch
+	}
+	if !void && st.ToBranchId != uuid.Nil {
+		if err := c.checkLedgersTrading(ctx, c.pgc, st.ToBranchId, st.Ledger); err != nil {
+			return nil, err
+		}
+	}
+
+	pending, err := c.tbc.LookupTransfers([]tbTypes.Uint128{tbPendingId})
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup transfers request to TB: %w", err)
+	}
+	if len(pending) != 1 {
+		return nil, fmt.Errorf("core: pending transfer for stock transfer %s not found in TB", tbPendingId)
+	}
+
+	transfers := resolvePendingTransfers(void, pending[0])
+	newState := StockVoided
+	if !void {
+		newState = StockReceived
+		destIds, err := c.branchIds(ctx, receiverId)
+		if err != nil {
+			return nil, err
+		}
+		destination := destIds.liquidity[st.Ledger]
+		if st.ToBranchId == uuid.Nil {
+			destination = destIds.wholesale[st.Ledger]
+		}
+
+		transfers[0].Flags = tbTypes.TransferFlags{
+			Linked:              true,
+			PostPendingTransfer: true,
+		}.ToUint16()
+		st.TbReceiptId = tbTypes.ID()
+		transfers = append(transfers, tbTypes.Transfer{
+			ID:              st.TbReceiptId,
+			DebitAccountID:  destination,
+			CreditAccountID: pending[0].DebitAccountID,
+			Amount:          pending[0].Amount,
+			UserData128:     tbPendingId,
+			Ledger:          pending[0].Ledger,
+			Code:            uint16(TransferCodeStock),
+		})
+	}
+	if err := c.createTransfers(transfers); err != nil {
+		return nil, err
+	}
+
+	var receiptId *uuid.UUID
+	if !void {
+		id := tbToUuid(st.TbReceiptId)
+		receiptId = &id
+	}
+	if err := tx.QueryRow(
+		ctx,
+		"UPDATE stock_transfers SET state = $1, tb_receipt_id = $2, resolved_by = $3, resolved_at = NOW() WHERE tb_pending_id = $4 RETURNING resolved_at",
+		newState,
+		receiptId,
+		operatorId,
+		tbToUuid(tbPendingId),
+	).Scan(&st.ResolvedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	st.State = newState
+	st.ResolvedBy = operatorId
+
+	c.Logger.Info(
+		"stock transfer resolved",
+		"tb_pending_id",
+		tbPendingId,
+		"state",
+		newState,
+		"consignment_ref",
+		st.ConsignmentRef,
+	)
+	return st, nil
+}
+
+// GetStockTransfer gets a stock transfer by the ID of its pending dispatch transfer.
+func (c *Core) GetStockTransfer(ctx context.Context, tbPendingId tbTypes.Uint128) (*StockTransfer, error) {
+	return scanStockTransfer(c.pgc.QueryRow(ctx, sqlSelectStockTransfers+" WHERE tb_pending_id = $1", tbToUuid(tbPendingId)))
+}
+
+// ListStockTransfers lists the stock transfers to or from a branch, newest first. A Nil branchId lists transfers for every
+// branch, and an empty state lists transfers in any state.
+func (c *Core) ListStockTransfers(
+	ctx context.Context,
+	branchId uuid.UUID,
+	state StockTransferState,
+	dateRange TimeRange,
+) ([]StockTransfer, error) {
+	from, to := dateRange.bounds()
+	rows, err := c.pgc.Query(
+		ctx,
+		sqlSelectStockTransfers+" WHERE ($1::UUID IS NULL OR from_branch_id = $1 OR to_branch_id = $1) AND ($2::TEXT = '' OR state = $2) AND ($3::TIMESTAMPTZ IS NULL OR dispatched_at >= $3) AND ($4::TIMESTAMPTZ IS NULL OR dispatched_at < $4) ORDER BY dispatched_at DESC",
+		nullableId(branchId),
+		state,
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (StockTransfer, error) {
+		st, err := scanStockTransfer(row)
+		if err != nil {
+			return StockTransfer{}, err
+		}
+		return *st, nil
+	})
+}
+
+// stockInTransitFrom sums the stock a branch has dispatched from its liquidity account that has not yet been received or
+// voided. It is still pending against the account.
+func (c *Core) stockInTransitFrom(ctx context.Context, branchId uuid.UUID, ledger Ledger) (uint64, error) {
+	var amount uint64
+	err := c.pgc.QueryRow(
+		ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM stock_transfers WHERE from_branch_id = $1 AND ledger_id = $2 AND state = $3",
+		branchId,
+		ledger,
+		StockInTransit,
+	).Scan(&amount)
+	return amount, err
+}
+
+const sqlSelectStockTransfers = "SELECT tb_pending_id, tb_receipt_id, from_branch_id, to_branch_id, COALESCE(supplier, ''), ledger_id, amount, expected_value, consignment_ref, COALESCE(courier_ref, ''), state, dispatched_by, dispatched_at, resolved_by, resolved_at FROM stock_transfers"
+
+// scanStockTransfer scans a row selected with sqlSelectStockTransfers.
+func scanStockTransfer(row pgx.Row) (*StockTransfer, error) {
+	var st StockTransfer
+	var pendingId uuid.UUID
+	var receiptId, fromBranchId, toBranchId, resolvedBy *uuid.UUID
+	var resolvedAt *time.Time
+	if err := row.Scan(
+		&pendingId,
+		&receiptId,
+		&fromBranchId,
+		&toBranchId,
+		&st.Supplier,
+		&st.Ledger,
+		&st.Amount,
+		&st.ExpectedValue,
+		&st.ConsignmentRef,
+		&st.CourierRef,
+		&st.State,
+		&st.DispatchedBy,
+		&st.DispatchedAt,
+		&resolvedBy,
+		&resolvedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	st.TbPendingId = uuidToTb(pendingId)
+	if receiptId != nil {
+		st.TbReceiptId = uuidToTb(*receiptId)
+	}
+	if fromBranchId != nil {
+		st.FromBranchId = *fromBranchId
+	}
+	if toBranchId != nil {
+		st.ToBranchId = *toBranchId
+	}
+	if resolvedBy != nil {
+		st.ResolvedBy = *resolvedBy
+	}
+	if resolvedAt != nil {
+		st.ResolvedAt = *resolvedAt
+	}
+	return &st, nil
+}
+
+// StockLevel holds the thresholds a branch's stock of one currency should stay within, in display units. The minimum is the
+// level to reorder at, and the maximum is usually set by the branch's insurance cover. Zero disables either threshold.
+type StockLevel struct {
+	Min decimal.Decimal
+	Max decimal.Decimal
+}
+
+// StockBreach describes a branch's stock of a currency outside its StockLevel.
+type StockBreach struct {
+	BranchId  uuid.UUID
+	Ledger    Ledger
+	Kind      StockBreachKind
+	Stock     int64  // In the ledger's minor unit
+	Threshold uint64 // In the ledger's minor unit
+}
+
+// CheckStockLevels reads a branch's stock of every ledger with a StockLevel from TB and returns any breaches, or checks every
+// branch if branchId is Nil. A branch's stock is the posted balance of its safe and tills, less any cash reserved to leave
+// them by pending trades and stock transfers.
+func (c *Core) CheckStockLevels(ctx context.Context, branchId uuid.UUID) ([]StockBreach, error) {
+	ledgers := make([]Ledger, 0, len(c.options.StockLevels))
+	for ledger := range c.options.StockLevels {
+		ledgers = append(ledgers, ledger)
+	}
+	slices.Sort(ledgers)
+
+	var branchIds []uuid.UUID
+	if branchId == uuid.Nil {
+		branches, err := c.ListBranches(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range branches {
+			branchIds = append(branchIds, b.Id)
+		}
+	} else {
+		branchIds = []uuid.UUID{branchId}
+	}
+
+	breaches := []StockBreach{}
+	for _, id := range branchIds {
+		stock, err := c.branchStock(ctx, id, ledgers...)
+		if err != nil {
+			return nil, err
+		}
+		for _, ledger := range ledgers {
+			if breach := c.stockBreach(id, ledger, stock[ledger]); breach != nil {
+				breaches = append(breaches, *breach)
+			}
+		}
+	}
+
+	return breaches, nil
+}
+
+// branchStock sums the stock held in a branch's safe and tills for each ledger, in minor units.
+func (c *Core) branchStock(ctx context.Context, branchId uuid.UUID, ledgers ...Ledger) (map[Ledger]int64, error) {
+	ids, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return nil, err
+	}
+	tills, err := c.ListTills(ctx, branchId)
+	if err != nil {
+		return nil, err
+	}
+
+	accountIds := make([]tbTypes.Uint128, 0, len(ledgers)*(len(tills)+1))
+	for _, ledger := range ledgers {
+		accountIds = append(accountIds, ids.liquidity[ledger])
+		for _, till := range tills {
+			accountIds = append(accountIds, c.tillAccountIds(ids, till.Id, ledger).liquidity)
+		}
+	}
+	accounts, err := c.lookupAccounts(accountIds)
+	if err != nil {
+		return nil, err
+	}
+
+	stock := make(map[Ledger]int64, len(ledgers))
+	for _, account := range accounts {
+		stock[Ledger(account.Ledger)] += availableStock(account)
+	}
+	return stock, nil
+}
+
+// stockBreach checks a stock level against the ledger's thresholds, returning nil if it is within them.
+func (c *Core) stockBreach(branchId uuid.UUID, ledger Ledger, stock int64) *StockBreach {
+	level, ok := c.options.StockLevels[ledger]
+	if !ok {
+		return nil
+	}
+
+	// Both thresholds are checked by New, so they always convert.
+	if level.Min.IsPositive() {
+		if threshold, err := toMinorUnits(level.Min, ledger); err == nil && stock < int64(threshold) {
+			return &StockBreach{BranchId: branchId, Ledger: ledger, Kind: StockBelowMin, Stock: stock, Threshold: threshold}
+		}
+	}
+	if level.Max.IsPositive() {
+		if threshold, err := toMinorUnits(level.Max, ledger); err == nil && stock > int64(threshold) {
+			return &StockBreach{BranchId: branchId, Ledger: ledger, Kind: StockAboveMax, Stock: stock, Threshold: threshold}
+		}
+	}
+	return nil
+}
+
+// checkTradeStockLevels reports every threshold that a newly booked trade has pushed the branch's stock across. It must be
+// called while the trade's transfers are still pending: cash paid out is reserved as soon as the trade is booked, so the
+// stock already reflects it, while cash taken in is counted as if the trade has been posted. Errors are only logged, since
+// the trade has already been booked.
+func (c *Core) checkTradeStockLevels(ctx context.Context, branchId uuid.UUID, given tradeSide, paidOut ...tradeSide) {
+	ledgers := []Ledger{}
+	for _, side := range append([]tradeSide{given}, paidOut...) {
+		if _, ok := c.options.StockLevels[side.ledger]; ok && !slices.Contains(ledgers, side.ledger) {
+			ledgers = append(ledgers, side.ledger)
+		}
+	}
+	if len(ledgers) == 0 {
+		return
+	}
+
+	stock, err := c.branchStock(ctx, branchId, ledgers...)
+	if err != nil {
+		c.Logger.Error("failed to check stock levels after trade", "branch_id", branchId, "error", err)
+		return
+	}
+
+	for _, ledger := range ledgers {
+		before, after := stock[ledger], stock[ledger]
+		for _, side := range paidOut {
+			if ledger == side.ledger {
+				before += int64(side.amount)
+			}
+		}
+		if ledger == given.ledger {
+			after += int64(given.amount)
+		}
+
+		breach := c.stockBreach(branchId, ledger, after)
+		if breach == nil {
+			continue
+		}
+		if previous := c.stockBreach(branchId, ledger, before); previous != nil && previous.Kind == breach.Kind {
+			continue
+		}
+
+		c.Logger.Warn(
+			"trade pushed stock across threshold",
+			"branch_id",
+			branchId,
+			"ledger",
+			ledger,
+			"kind",
+			breach.Kind,
+			"stock",
+			breach.Stock,
+			"threshold",
+			breach.Threshold,
+		)
+		if c.options.OnStockBreach != nil {
+			c.options.OnStockBreach(*breach)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+// Defaults for StructuringPolicy fields that are not set.
+const (
+	DefaultStructuringWindow    = 24 * time.Hour
+	DefaultStructuringMinTrades = 3
+)
+
+// DefaultStructuringBand is used when StructuringPolicy.Band is not set.
+var DefaultStructuringBand = decimal.RequireFromString("0.1")
+
+// StructuringPolicy sets what counts as structuring: splitting an exchange into smaller trades to stay under a threshold.
+type StructuringPolicy struct {
+	// Threshold is the local currency amount customers might be trying to stay under. Defaults to Cdd.Id.SingleTrade; if
+	// neither is set, ScanStructuring finds nothing.
+	Threshold decimal.Decimal
+	// Band is how far under the Threshold a trade counts as just under it, as a fraction of the Threshold.
+	// Defaults to DefaultStructuringBand, i.e. trades within 10% of the Threshold.
+	Band decimal.Decimal
+	// Window is how far back ScanStructuring looks. Defaults to DefaultStructuringWindow.
+	Window time.Duration
+	// MinTrades is how many trades just under the Threshold one customer must make within the Window to be flagged.
+	// Defaults to DefaultStructuringMinTrades.
+	MinTrades int
+}
+
+func (p *StructuringPolicy) setDefaults() {
+	if p.Band.IsZero() {
+		p.Band = DefaultStructuringBand
+	}
+	if p.Window == 0 {
+		p.Window = DefaultStructuringWindow
+	}
+	if p.MinTrades == 0 {
+		p.MinTrades = DefaultStructuringMinTrades
+	}
+}
+
+// StructuringHit is a set of trades found by ScanStructuring. Each trade is marked unusual with Reason, which blocks its
+// customer.
+type StructuringHit struct {
+	Kind        StructuringKind
+	CustomerIds []uuid.UUID
+	TradeIds    []tbTypes.Uint128
+	Total       decimal.Decimal // The local currency value of the trades
+	Reason      string
+}
+
+// ScanStructuring looks through the trades in the last Options.Structuring.Window for two patterns: one customer making
+// several trades just under the threshold, and customers sharing an address and postcode who each stay under the threshold
+// with trades just under it but go over it between them. Every trade in a hit is marked unusual, and
+// fn_block_unusual_customers blocks the customers. Their risk is then reassessed.
+//
+// Voided, expired and reversed trades are ignored, as are hits whose trades are all already unusual, so it is safe to call
+// periodically.
+func (c *Core) ScanStructuring(ctx context.Context) ([]StructuringHit, error) {
+	policy := c.options.Structuring
+	threshold := policy.Threshold
+	if threshold.IsZero() {
+		threshold = c.options.Cdd.Id.SingleTrade
+	}
+	if !threshold.IsPositive() {
+		return nil, nil
+	}
+	floor := threshold.Sub(threshold.Mul(policy.Band))
+
+	// Collect every live trade in the window, with its local currency value.
+	byCustomer := map[uuid.UUID][]structuringTrade{}
+	filter := TradeFilter{DateRange: TimeRange{From: time.Now().Add(-policy.Window)}}
+	var after TradeCursor
+	for {
+		trades, next, err := c.ListTrades(ctx, filter, after, MaxTradesPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, trade := range trades {
+			if trade.State != TradePending && trade.State != TradePosted {
+				continue
+			}
+			byCustomer[trade.CustomerId] = append(
+				byCustomer[trade.CustomerId],
+				structuringTrade{trade: trade, value: c.tradeLocalValue(&trade)},
+			)
+		}
+		if next.CreatedAt.IsZero() {
+			break
+		}
+		after = next
+	}
+	if len(byCustomer) == 0 {
+		return nil, nil
+	}
+
+	hits := []StructuringHit{}
+	addHit := func(kind StructuringKind, customerIds []uuid.UUID, trades []structuringTrade, reason string) {
+		hit := StructuringHit{Kind: kind, CustomerIds: customerIds, Reason: reason}
+		allUnusual := true
+		for _, s := range trades {
+			hit.TradeIds = append(hit.TradeIds, s.trade.TbPendingId)
+			hit.Total = hit.Total.Add(s.value)
+			allUnusual = allUnusual && s.trade.IsUnusual
+		}
+		if !allUnusual {
+			hits = append(hits, hit)
+		}
+	}
+
+	customerTotals := make(map[uuid.UUID]decimal.Decimal, len(byCustomer))
+	customerJustUnder := make(map[uuid.UUID][]structuringTrade, len(byCustomer))
+	for _, customerId := range slices.SortedFunc(maps.Keys(byCustomer), compareUuids) {
+		justUnder, total := tradesJustUnder(byCustomer[customerId], floor, threshold)
+		customerTotals[customerId] = total
+		customerJustUnder[customerId] = justUnder
+
+		if len(justUnder) >= policy.MinTrades {
+			addHit(StructuringCustomer, []uuid.UUID{customerId}, justUnder, fmt.Sprintf(
+				"Structuring: %d trades from %s up to the %s threshold within %s",
+				len(justUnder),
+				floor.StringFixed(CurrencyAssetScales[c.options.LocalCurrencyLedger]),
+				threshold,
+				policy.Window,
+			))
+		}
+	}
+
+	households, err := c.customerHouseholds(ctx, slices.Collect(maps.Keys(byCustomer)))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(households)) {
+		customerIds := households[key]
+		if len(customerIds) < 2 {
+			continue
+		}
+		slices.SortFunc(customerIds, compareUuids)
+
+		if trades, total, ok := householdSplit(customerIds, customerJustUnder, customerTotals, threshold); ok {
+			addHit(StructuringAddress, customerIds, trades, fmt.Sprintf(
+				"Structuring: %d customers at one address in %s each traded just under the threshold within %s, %s in all, over %s",
+				len(customerIds),
+				strings.SplitN(key, "|", 2)[0],
+				policy.Window,
+				total,
+				threshold,
+			))
+		}
+	}
+
+	for _, hit := range hits {
+		if err := c.markTradesUnusual(ctx, hit.TradeIds, hit.Reason); err != nil {
+			return nil, err
+		}
+		c.Logger.Warn(
+			"structuring detected",
+			"kind",
+			hit.Kind,
+			"customer_ids",
+			hit.CustomerIds,
+			"trades",
+			len(hit.TradeIds),
+			"total",
+			hit.Total,
+		)
+		for _, customerId := range hit.CustomerIds {
+			if _, err := c.AssessCustomerRisk(ctx, customerId); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return hits, nil
+}
+
+// structuringTrade is a live trade in ScanStructuring's window, with its local currency value.
+type structuringTrade struct {
+	trade FxTrade
+	value decimal.Decimal
+}
+
+// tradesJustUnder picks out the trades worth at least floor but less than threshold, and adds up the value of all of them.
+func tradesJustUnder(trades []structuringTrade, floor, threshold decimal.Decimal) ([]structuringTrade, decimal.Decimal) {
+	var justUnder []structuringTrade
+	total := decimal.Zero
+	for _, s := range trades {
+		total = total.Add(s.value)
+		if s.value.GreaterThanOrEqual(floor) && s.value.LessThan(threshold) {
+			justUnder = append(justUnder, s)
+		}
+	}
+	return justUnder, total
+}
+
+// householdSplit reports whether a household split an exchange between them: every customer traded just under the
+// threshold and stayed at or under it in all, but their trades just under it add up to more than it. Only trades just
+// under the threshold count, and every customer must have made one, so a household that simply exchanges money between
+// them now and then is not flagged. It returns the household's trades just under the threshold and their total.
+func householdSplit(
+	customerIds []uuid.UUID,
+	justUnder map[uuid.UUID][]structuringTrade,
+	totals map[uuid.UUID]decimal.Decimal,
+	threshold decimal.Decimal,
+) ([]structuringTrade, decimal.Decimal, bool) {
+	var trades []structuringTrade
+	total := decimal.Zero
+	split := true
+	for _, customerId := range customerIds {
+		trades = append(trades, justUnder[customerId]...)
+		for _, s := range justUnder[customerId] {
+			total = total.Add(s.value)
+		}
+		split = split && len(justUnder[customerId]) > 0 && totals[customerId].LessThanOrEqual(threshold)
+	}
+	return trades, total, split && total.GreaterThan(threshold)
+}
+
+// compareUuids orders UUIDs bytewise, which for V7 UUIDs is the order they were created in.
+func compareUuids(a uuid.UUID, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// customerHouseholds groups customers by their normalised postcode and address, keyed by "POSTCODE|address".
+func (c *Core) customerHouseholds(ctx context.Context, customerIds []uuid.UUID) (map[string][]uuid.UUID, error) {
+	rows, err := c.pgc.Query(ctx, "SELECT id, address, postcode FROM customers WHERE id = ANY($1)", customerIds)
+	if err != nil {
+		return nil, err
+	}
+
+	households := map[string][]uuid.UUID{}
+	var id uuid.UUID
+	var address, postcode string
+	if _, err := pgx.ForEachRow(rows, []any{&id, &address, &postcode}, func() error {
+		key := NormalisePostcode(postcode) + "|" + strings.ToLower(strings.Join(strings.Fields(address), " "))
+		households[key] = append(households[key], id)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return households, nil
+}
+
+// markTradesUnusual sets is_unusual and unusual_reason on trades not already marked, which fires
+// fn_block_unusual_customers for each.
+func (c *Core) markTradesUnusual(ctx context.Context, tradeIds []tbTypes.Uint128, reason string) error {
+	ids := make([]uuid.UUID, len(tradeIds))
+	for i, id := range tradeIds {
+		ids[i] = tbToUuid(id)
+	}
+
+	_, err := c.pgc.Exec(
+		ctx,
+		"UPDATE fx_trades SET is_unusual = TRUE, unusual_reason = $1 WHERE tb_pending_id = ANY($2) AND NOT is_unusual",
+		reason,
+		ids,
+	)
+	return err
+}
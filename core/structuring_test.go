@@ -0,0 +1,171 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestStructuringPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy StructuringPolicy
+		want   StructuringPolicy
+	}{
+		{
+			name:   "zero",
+			policy: StructuringPolicy{},
+			want: StructuringPolicy{
+				Band:      DefaultStructuringBand,
+				Window:    DefaultStructuringWindow,
+				MinTrades: DefaultStructuringMinTrades,
+			},
+		},
+		{
+			name: "all set",
+			policy: StructuringPolicy{
+				Threshold: decimal.NewFromInt(5000),
+				Band:      decimal.RequireFromString("0.2"),
+				Window:    72 * time.Hour,
+				MinTrades: 5,
+			},
+			want: StructuringPolicy{
+				Threshold: decimal.NewFromInt(5000),
+				Band:      decimal.RequireFromString("0.2"),
+				Window:    72 * time.Hour,
+				MinTrades: 5,
+			},
+		},
+		{
+			name:   "threshold has no default",
+			policy: StructuringPolicy{MinTrades: 2},
+			want: StructuringPolicy{
+				Band:      DefaultStructuringBand,
+				Window:    DefaultStructuringWindow,
+				MinTrades: 2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy
+			got.setDefaults()
+			if !got.Threshold.Equal(tt.want.Threshold) ||
+				!got.Band.Equal(tt.want.Band) ||
+				got.Window != tt.want.Window ||
+				got.MinTrades != tt.want.MinTrades {
+				t.Errorf("setDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func structuringTrades(values ...string) []structuringTrade {
+	trades := make([]structuringTrade, len(values))
+	for i, v := range values {
+		trades[i] = structuringTrade{value: decimal.RequireFromString(v)}
+	}
+	return trades
+}
+
+func TestTradesJustUnder(t *testing.T) {
+	floor := decimal.NewFromInt(900)
+	threshold := decimal.NewFromInt(1000)
+	tests := []struct {
+		name      string
+		values    []string
+		justUnder int
+		total     string
+	}{
+		{name: "none", values: nil, justUnder: 0, total: "0"},
+		{name: "below the floor", values: []string{"899.99", "100"}, justUnder: 0, total: "999.99"},
+		{name: "at the floor", values: []string{"900"}, justUnder: 1, total: "900"},
+		{name: "just under", values: []string{"950", "999.99", "10"}, justUnder: 2, total: "1959.99"},
+		{name: "at the threshold", values: []string{"1000"}, justUnder: 0, total: "1000"},
+		{name: "over the threshold", values: []string{"1500", "920"}, justUnder: 1, total: "2420"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			justUnder, total := tradesJustUnder(structuringTrades(tt.values...), floor, threshold)
+			if len(justUnder) != tt.justUnder {
+				t.Errorf("tradesJustUnder picked %d trades, want %d", len(justUnder), tt.justUnder)
+			}
+			if !total.Equal(decimal.RequireFromString(tt.total)) {
+				t.Errorf("tradesJustUnder total = %s, want %s", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestHouseholdSplit(t *testing.T) {
+	floor := decimal.NewFromInt(900)
+	threshold := decimal.NewFromInt(1000)
+	a, b, c := uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name   string
+		trades map[uuid.UUID][]string
+		want   bool
+		total  string
+	}{
+		{
+			name:   "each just under, over between them",
+			trades: map[uuid.UUID][]string{a: {"950"}, b: {"960"}},
+			want:   true,
+			total:  "1910",
+		},
+		{
+			name:   "three customers",
+			trades: map[uuid.UUID][]string{a: {"950"}, b: {"960"}, c: {"905", "40"}},
+			want:   true,
+			total:  "2815",
+		},
+		{
+			name:   "one customer made no trade just under",
+			trades: map[uuid.UUID][]string{a: {"950"}, b: {"400", "450"}},
+			want:   false,
+			total:  "950",
+		},
+		{
+			name:   "one customer never traded",
+			trades: map[uuid.UUID][]string{a: {"950"}},
+			want:   false,
+			total:  "950",
+		},
+		{
+			name:   "one customer went over on their own",
+			trades: map[uuid.UUID][]string{a: {"950"}, b: {"960", "100"}},
+			want:   false,
+			total:  "1910",
+		},
+		{
+			name:   "small trades between them don't count",
+			trades: map[uuid.UUID][]string{a: {"950", "10"}, b: {"60"}},
+			want:   false,
+			total:  "950",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customerIds := []uuid.UUID{a, b}
+			if _, ok := tt.trades[c]; ok {
+				customerIds = append(customerIds, c)
+			}
+			justUnder := map[uuid.UUID][]structuringTrade{}
+			totals := map[uuid.UUID]decimal.Decimal{}
+			for customerId, values := range tt.trades {
+				justUnder[customerId], totals[customerId] = tradesJustUnder(structuringTrades(values...), floor, threshold)
+			}
+
+			_, total, got := householdSplit(customerIds, justUnder, totals, threshold)
+			if got != tt.want {
+				t.Errorf("householdSplit = %t, want %t", got, tt.want)
+			}
+			if !total.Equal(decimal.RequireFromString(tt.total)) {
+				t.Errorf("householdSplit total = %s, want %s", total, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,667 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+var (
+	ErrTillInactive     = errors.New("core: till is not active")
+	ErrTillAssigned     = errors.New("core: till is already assigned to another operator")
+	ErrInvalidFloat     = errors.New("core: float direction must be TO_TILL or TO_SAFE")
+	ErrTillsNotCashedUp = errors.New("core: tills still hold cash and must be cashed up first")
+)
+
+// Till is a teller's cash drawer. Each till has its own liquidity, overs and shorts accounts in TB for every ledger, so that
+// the cash it holds and any discrepancies found when it is cashed up can be traced to the operator it is assigned to.
+// The branch liquidity accounts hold the cash in the safe. Tills belong to one branch and exchange float with its safe.
+type Till struct {
+	Id        uuid.UUID
+	BranchId  uuid.UUID
+	Name      string
+	CreatedAt time.Time
+	IsActive  bool
+}
+
+// tillAccounts holds a till's TB account IDs for one ledger.
+type tillAccounts struct {
+	liquidity tbTypes.Uint128
+	overs     tbTypes.Uint128
+	shorts    tbTypes.Uint128
+}
+
+// tillAccountIds derives a till's account IDs for a ledger from the namespace, so they never need to be stored. The
+// liquidity account follows its branch's liquidity generation.
+func (c *Core) tillAccountIds(ids *knownIds, tillId uuid.UUID, ledger Ledger) tillAccounts {
+	return tillAccounts{
+		liquidity: idWithNamespace(
+			c.namespace,
+			liquidityKey(fmt.Sprintf("till_liquidity_%s_%d", tillId, ledger), ids.generation),
+		),
+		overs:  idWithNamespace(c.namespace, fmt.Sprintf("till_overs_%s_%d", tillId, ledger)),
+		shorts: idWithNamespace(c.namespace, fmt.Sprintf("till_shorts_%s_%d", tillId, ledger)),
+	}
+}
+
+// liquidityId gets the liquidity account that a till trades from, or the branch safe's if tillId is Nil.
+func (c *Core) liquidityId(ids *knownIds, tillId uuid.UUID, ledger Ledger) tbTypes.Uint128 {
+	if tillId == uuid.Nil {
+		return ids.liquidity[ledger]
+	}
+	return c.tillAccountIds(ids, tillId, ledger).liquidity
+}
+
+// CreateTill inserts a new till into a branch and creates its TB accounts for every ledger. Till names are unique within a
+// branch.
+func (c *Core) CreateTill(ctx context.Context, branchId uuid.UUID, name string) (*Till, error) {
+	if err := c.checkBranchActive(ctx, branchId); err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.createTillAccounts(ids, id); err != nil {
+		return nil, err
+	}
+
+	till := &Till{Id: id, BranchId: branchId, Name: name}
+	if err := c.pgc.QueryRow(ctx, "INSERT INTO tills (id, branch_id, name) VALUES ($1, $2, $3) RETURNING created_at, is_active", id, branchId, name).
+		Scan(&till.CreatedAt, &till.IsActive); err != nil {
+		return nil, err
+	}
+
+	c.Logger.Info("till created", "till_id", id, "branch_id", branchId, "name", name)
+	return till, nil
+}
+
+// createTillAccounts ensures a till's liquidity, overs and shorts accounts exist in TB for every ledger, with liquidity in
+// its branch's current generation.
+func (c *Core) createTillAccounts(branchIds *knownIds, tillId uuid.UUID) error {
+	accounts := make([]tbTypes.Account, 0, len(CurrencyAssetScales)*3)
+	for ledger := range CurrencyAssetScales {
+		ids := c.tillAccountIds(branchIds, tillId, ledger)
+		accounts = append(accounts,
+			tbTypes.Account{
+				ID:          ids.liquidity,
+				UserData128: uuidToTb(tillId),
+				Ledger:      uint32(ledger),
+				Code:        uint16(AccountCodeTillLiquidity),
+				Flags:       liquidityFlags(branchIds.generation),
+			},
+			tbTypes.Account{
+				ID:          ids.overs,
+				UserData128: uuidToTb(tillId),
+				Ledger:      uint32(ledger),
+				Code:        uint16(AccountCodeTillOvers),
+				Flags: tbTypes.AccountFlags{
+					DebitsMustNotExceedCredits: true,
+					History:                    true,
+				}.ToUint16(),
+			},
+			tbTypes.Account{
+				ID:          ids.shorts,
+				UserData128: uuidToTb(tillId),
+				Ledger:      uint32(ledger),
+				Code:        uint16(AccountCodeTillShorts),
+				Flags: tbTypes.AccountFlags{
+					CreditsMustNotExceedDebits: true,
+					History:                    true,
+				}.ToUint16(),
+			},
+		)
+	}
+
+	accountErrors, err := c.tbc.CreateAccounts(accounts)
+	if err != nil {
+		return fmt.Errorf("core: failed to send create accounts request to TB: %w", err)
+	}
+	for _, e := range accountErrors {
+		if e.Result != tbTypes.AccountOK && e.Result != tbTypes.AccountExists {
+			return fmt.Errorf("core: failed to create till account: %s", e.Result)
+		}
+	}
+
+	return nil
+}
+
+// GetTill queries the database for a till by id.
+func (c *Core) GetTill(ctx context.Context, id uuid.UUID) (*Till, error) {
+	till := &Till{}
+	if err := c.pgc.QueryRow(ctx, "SELECT id, branch_id, name, created_at, is_active FROM tills WHERE id = $1", id).
+		Scan(&till.Id, &till.BranchId, &till.Name, &till.CreatedAt, &till.IsActive); err != nil {
+		return nil, err
+	}
+
+	return till, nil
+}
+
+// ListTills gets every till in a branch, active or not, ordered by name. A Nil branchId lists tills in every branch.
+func (c *Core) ListTills(ctx context.Context, branchId uuid.UUID) ([]Till, error) {
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT id, branch_id, name, created_at, is_active FROM tills WHERE ($1::UUID IS NULL OR branch_id = $1) ORDER BY name",
+		nullableId(branchId),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Till, error) {
+		var t Till
+		err := row.Scan(&t.Id, &t.BranchId, &t.Name, &t.CreatedAt, &t.IsActive)
+		return t, err
+	})
+}
+
+// SetTillActivated sets the is_active field of a till to the given value. Inactive tills can't be issued float.
+func (c *Core) SetTillActivated(ctx context.Context, id uuid.UUID, isActive bool) error {
+	if _, err := c.pgc.Exec(
+		ctx,
+		"UPDATE tills SET is_active = $1 WHERE id = $2",
+		isActive,
+		id,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AssignTill assigns a till to an operator, whose trades are then paid in and out of it. Pass uuid.Nil to unassign the
+// operator's till, so that they trade from the safe. A till can only be assigned to one operator at a time, and only to
+// operators in its branch.
+func (c *Core) AssignTill(ctx context.Context, operatorId uuid.UUID, tillId uuid.UUID) error {
+	var till *uuid.UUID
+	if tillId != uuid.Nil {
+		op, err := c.GetOperator(ctx, operatorId, "")
+		if err != nil {
+			return err
+		}
+		t, err := c.GetTill(ctx, tillId)
+		if err != nil {
+			return err
+		}
+		if !t.IsActive {
+			return ErrTillInactive
+		}
+		if t.BranchId != op.BranchId {
+			return ErrBranchMismatch
+		}
+		till = &tillId
+	}
+
+	if _, err := c.pgc.Exec(
+		ctx,
+		"UPDATE operators SET till_id = $1 WHERE id = $2",
+		till,
+		operatorId,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrTillAssigned
+		}
+		return err
+	}
+
+	return nil
+}
+
+// FloatTransfer records float moved between the safe and a till.
+type FloatTransfer struct {
+	TbTransferId tbTypes.Uint128
+	TillId       uuid.UUID
+	Ledger       Ledger
+	OperatorId   uuid.UUID
+	Direction    FloatDirection
+	Amount       uint64 // In the ledger's minor unit
+	CreatedAt    time.Time
+}
+
+// MoveFloat moves an amount of a ledger's cash, in display units, from the branch safe into a till or from a till back to the
+// safe. The operator must belong to the till's branch.
+// The transfer is created pending and posted once it is recorded in PG.
+func (c *Core) MoveFloat(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	tillId uuid.UUID,
+	ledger Ledger,
+	direction FloatDirection,
+	amount decimal.Decimal,
+) (*FloatTransfer, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	till, err := c.GetTill(ctx, tillId)
+	if err != nil {
+		return nil, err
+	}
+	if direction == FloatToTill && !till.IsActive {
+		return nil, ErrTillInactive
+	}
+	if till.BranchId != op.BranchId {
+		return nil, ErrBranchMismatch
+	}
+	// Like an inactive till, an inactive branch can still have float moved back to its safe.
+	if direction == FloatToTill {
+		if err := c.checkBranchActive(ctx, till.BranchId); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := CurrencyAssetScales[ledger]; !ok {
+		return nil, ErrInvalidLedger
+	}
+	if !validAmount(amount, ledger) {
+		return nil, ErrInvalidAmount
+	}
+	if err := c.checkLedgersTrading(ctx, c.pgc, till.BranchId, ledger); err != nil {
+		return nil, err
+	}
+	minorAmount, err := toMinorUnits(amount, ledger)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := c.branchIds(ctx, till.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	ft := &FloatTransfer{
+		TbTransferId: tbTypes.ID(),
+		TillId:       tillId,
+		Ledger:       ledger,
+		OperatorId:   operatorId,
+		Direction:    direction,
+		Amount:       minorAmount,
+	}
+	transfer := tbTypes.Transfer{
+		ID:     ft.TbTransferId,
+		Amount: tbTypes.ToUint128(ft.Amount),
+		Ledger: uint32(ledger),
+		Code:   uint16(TransferCodeFloat),
+		Flags: tbTypes.TransferFlags{
+			Pending: true,
+		}.ToUint16(),
+	}
+	switch direction {
+	case FloatToTill:
+		transfer.DebitAccountID = c.liquidityId(ids, tillId, ledger)
+		transfer.CreditAccountID = ids.liquidity[ledger]
+	case FloatToSafe:
+		transfer.DebitAccountID = ids.liquidity[ledger]
+		transfer.CreditAccountID = c.liquidityId(ids, tillId, ledger)
+	default:
+		return nil, ErrInvalidFloat
+	}
+
+	// Paying cash out credits a liquidity account, so the credited side is the one giving up stock.
+	paidOut := tradeSide{ledger: ledger, amount: minorAmount, liquidity: transfer.CreditAccountID}
+	if err := c.checkAvailableStock(paidOut.ledger, paidOut.liquidity, paidOut.amount); err != nil {
+		return nil, err
+	}
+
+	// The branch's day is held until the move is recorded, and checked again under it, so CloseDay and CashUpTill can't
+	// count the cash halfway through.
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if err := shareBranchDay(ctx, tx, till.BranchId); err != nil {
+		return nil, err
+	}
+	if err := c.checkLedgersTrading(ctx, tx, till.BranchId, ledger); err != nil {
+		return nil, err
+	}
+
+	if err := c.createTransfers([]tbTypes.Transfer{transfer}); err != nil {
+		return nil, c.stockError(err, paidOut)
+	}
+	err = tx.QueryRow(
+		ctx,
+		"INSERT INTO float_transfers (tb_transfer_id, till_id, ledger_id, operator_id, direction, amount) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at",
+		tbToUuid(ft.TbTransferId),
+		ft.TillId,
+		ft.Ledger,
+		ft.OperatorId,
+		ft.Direction,
+		ft.Amount,
+	).Scan(&ft.CreatedAt)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfer)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending float transfer after PG write failed",
+				"tb_transfer_id",
+				ft.TbTransferId,
+				"error",
+				voidErr,
+			)
+		}
+		return nil, err
+	}
+	if err := c.createTransfers(resolvePendingTransfers(false, transfer)); err != nil {
+		return nil, fmt.Errorf("core: float transfer recorded but could not be posted: %w", err)
+	}
+
+	c.Logger.Info(
+		"float moved",
+		"till_id",
+		ft.TillId,
+		"ledger",
+		ft.Ledger,
+		"direction",
+		ft.Direction,
+		"amount",
+		ft.Amount,
+	)
+	return ft, nil
+}
+
+// TillCashUp records a till being counted and emptied back into the safe.
+type TillCashUp struct {
+	TbPendingId   tbTypes.Uint128 // The transfer returning the counted cash to the safe, not created if nothing was counted
+	TillId        uuid.UUID
+	Ledger        Ledger
+	OperatorId    uuid.UUID
+	CountedAmount uint64 // In the ledger's minor unit
+	LedgerBalance int64  // The posted till balance in TB before any adjustment
+	CashedUpAt    time.Time
+	// Denominations is the counted breakdown by face value, or nil if the cash was counted by total.
+	Denominations map[Denomination]uint64
+
+	// Adjustment is nil if the count matched TB.
+	Adjustment *LedgerAdjustment
+}
+
+// CashUpTill counts each ledger in a till, e.g. at the end of a teller's shift.
+// Any difference from the till's posted TB balance is posted to the till's own overs or shorts account and recorded as a
+// ledger adjustment against the till. The counted cash is then returned to the branch safe, leaving the till empty.
+// The operator must belong to the till's branch.
+func (c *Core) CashUpTill(
+	ctx context.Context,
+	operatorId uuid.UUID,
+	tillId uuid.UUID,
+	counts map[Ledger]CashCount,
+) ([]TillCashUp, error) {
+	op, err := c.GetOperator(ctx, operatorId, "")
+	if err != nil {
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	till, err := c.GetTill(ctx, tillId)
+	if err != nil {
+		return nil, err
+	}
+	if till.BranchId != op.BranchId {
+		return nil, ErrBranchMismatch
+	}
+	if len(counts) == 0 {
+		return nil, ErrInvalidAmount
+	}
+	ids, err := c.branchIds(ctx, till.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers := make([]Ledger, 0, len(counts))
+	liquidityIds := make([]tbTypes.Uint128, 0, len(counts))
+	countedAmounts := make(map[Ledger]uint64, len(counts))
+	for ledger, count := range counts {
+		amount, err := count.minorUnits(ledger, c.denominations[ledger])
+		if err != nil {
+			return nil, err
+		}
+		countedAmounts[ledger] = amount
+		ledgers = append(ledgers, ledger)
+		liquidityIds = append(liquidityIds, c.liquidityId(ids, tillId, ledger))
+	}
+
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Like CloseDay, the till is read and swept under the branch's day lock, so no trade or float move at the till can
+	// change its balance in between.
+	if err := lockBranchDay(ctx, tx, till.BranchId); err != nil {
+		return nil, err
+	}
+	if err := c.checkLedgersOpen(ctx, tx, till.BranchId, ledgers...); err != nil {
+		return nil, err
+	}
+
+	accounts, err := c.tbc.LookupAccounts(liquidityIds)
+	if err != nil {
+		return nil, fmt.Errorf("core: failed to send lookup accounts request to TB: %w", err)
+	}
+	if len(accounts) != len(liquidityIds) {
+		return nil, errors.New("core: till accounts not found in TB")
+	}
+
+	cashUps := make([]TillCashUp, 0, len(ledgers))
+	transfers := []tbTypes.Transfer{}
+	for _, account := range accounts {
+		ledger := Ledger(account.Ledger)
+		if hasPending(account) {
+			return nil, fmt.Errorf("%w: %d", ErrPendingTransfers, ledger)
+		}
+
+		tillIds := c.tillAccountIds(ids, tillId, ledger)
+		counted := countedAmounts[ledger]
+		cashUp := TillCashUp{
+			TbPendingId:   tbTypes.ID(),
+			TillId:        tillId,
+			Ledger:        ledger,
+			OperatorId:    operatorId,
+			CountedAmount: counted,
+			LedgerBalance: liquidityBalance(account),
+			Denominations: counts[ledger].Denominations,
+		}
+
+		if adjustment, transfer := countAdjustment(
+			account,
+			cashUp.LedgerBalance,
+			counted,
+			operatorId,
+			tillIds.overs,
+			tillIds.shorts,
+			"Till cash-up",
+		); adjustment != nil {
+			adjustment.BranchId = till.BranchId
+			adjustment.TillId = tillId
+			transfers = append(transfers, transfer)
+			cashUp.Adjustment = adjustment
+		}
+
+		if counted > 0 {
+			transfers = append(transfers, tbTypes.Transfer{
+				ID:              cashUp.TbPendingId,
+				DebitAccountID:  ids.liquidity[ledger],
+				CreditAccountID: account.ID,
+				Amount:          tbTypes.ToUint128(counted),
+				Ledger:          uint32(ledger),
+				Code:            uint16(TransferCodeFloat),
+			})
+		}
+		cashUps = append(cashUps, cashUp)
+	}
+	for i := range transfers {
+		transfers[i].Flags = tbTypes.TransferFlags{
+			Linked:  i < len(transfers)-1,
+			Pending: true,
+		}.ToUint16()
+	}
+
+	for i := range cashUps {
+		cu := &cashUps[i]
+		if cu.Adjustment != nil {
+			if err := insertLedgerAdjustment(ctx, tx, cu.Adjustment); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := tx.QueryRow(
+			ctx,
+			"INSERT INTO till_cash_ups (tb_pending_id, till_id, ledger_id, operator_id, counted_amount, ledger_balance) VALUES ($1, $2, $3, $4, $5, $6) RETURNING cashed_up_at",
+			tbToUuid(cu.TbPendingId),
+			cu.TillId,
+			cu.Ledger,
+			cu.OperatorId,
+			cu.CountedAmount,
+			cu.LedgerBalance,
+		).Scan(&cu.CashedUpAt); err != nil {
+			return nil, err
+		}
+		if err := insertCountDenominations(
+			ctx,
+			tx,
+			CountTill,
+			tbToUuid(cu.TbPendingId),
+			cu.Ledger,
+			counts[cu.Ledger],
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(transfers) > 0 {
+		if err := c.createTransfers(transfers); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		if len(transfers) > 0 {
+			if voidErr := c.createTransfers(resolvePendingTransfers(true, transfers...)); voidErr != nil {
+				c.Logger.Error(
+					"failed to void pending cash-up transfers after PG commit failed",
+					"till_id",
+					tillId,
+					"error",
+					voidErr,
+				)
+			}
+		}
+		return nil, err
+	}
+	if len(transfers) > 0 {
+		if err := c.createTransfers(resolvePendingTransfers(false, transfers...)); err != nil {
+			return nil, fmt.Errorf("core: cash-ups recorded but cash-up transfers could not be posted: %w", err)
+		}
+	}
+
+	for _, cu := range cashUps {
+		if a := cu.Adjustment; a != nil {
+			c.Logger.Warn(
+				"till cashed up with a discrepancy",
+				"till_id",
+				cu.TillId,
+				"ledger",
+				cu.Ledger,
+				"operator_id",
+				cu.OperatorId,
+				"type",
+				a.Type,
+				"amount",
+				a.Amount,
+			)
+		} else {
+			c.Logger.Info("till cashed up", "till_id", cu.TillId, "ledger", cu.Ledger, "operator_id", cu.OperatorId)
+		}
+	}
+	return cashUps, nil
+}
+
+// GetTillAdjustments gets the overs and shorts found when a till was cashed up, newest first.
+func (c *Core) GetTillAdjustments(
+	ctx context.Context,
+	tillId uuid.UUID,
+	dateRange TimeRange,
+) ([]LedgerAdjustment, error) {
+	from, to := dateRange.bounds()
+	rows, err := c.pgc.Query(
+		ctx,
+		"SELECT tb_transfer_id, branch_id, ledger_id, operator_id, till_id, adjustment_type, amount, COALESCE(notes, ''), created_at FROM ledger_adjustments WHERE till_id = $1 AND ($2::TIMESTAMPTZ IS NULL OR created_at >= $2) AND ($3::TIMESTAMPTZ IS NULL OR created_at < $3) ORDER BY created_at DESC",
+		tillId,
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (LedgerAdjustment, error) {
+		var a LedgerAdjustment
+		var transferId uuid.UUID
+		err := row.Scan(
+			&transferId,
+			&a.BranchId,
+			&a.Ledger,
+			&a.OperatorId,
+			&a.TillId,
+			&a.Type,
+			&a.Amount,
+			&a.Notes,
+			&a.CreatedAt,
+		)
+		a.TbTransferId = uuidToTb(transferId)
+		return a, err
+	})
+}
+
+// checkTillsCashedUp returns ErrTillsNotCashedUp if any till in a branch holds cash, or has pending transfers, in the given
+// ledgers.
+func (c *Core) checkTillsCashedUp(ctx context.Context, branchId uuid.UUID, ledgers ...Ledger) error {
+	tills, err := c.ListTills(ctx, branchId)
+	if err != nil {
+		return err
+	}
+	if len(tills) == 0 {
+		return nil
+	}
+	branchIds, err := c.branchIds(ctx, branchId)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]tbTypes.Uint128, 0, len(tills)*len(ledgers))
+	for _, till := range tills {
+		for _, ledger := range ledgers {
+			ids = append(ids, c.tillAccountIds(branchIds, till.Id, ledger).liquidity)
+		}
+	}
+	accounts, err := c.lookupAccounts(ids)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if liquidityBalance(account) != 0 || hasPending(account) {
+			return fmt.Errorf("%w: till %s, ledger %d", ErrTillsNotCashedUp, tbToUuid(account.UserData128), account.Ledger)
+		}
+	}
+
+	return nil
+}
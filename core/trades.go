@@ -1,73 +1,935 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/shopspring/decimal"
 	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
 )
 
+var (
+	ErrInvalidDirection = errors.New("core: trade direction must be BUY or SELL")
+	ErrInvalidLedger    = errors.New("core: ledger must be a known foreign currency")
+	ErrInvalidAmount    = errors.New("core: amount must be positive and fit the ledger's asset scale")
+	ErrOperatorInactive = errors.New("core: operator is not active")
+
+	ErrTradeNotPending = errors.New("core: trade has already been posted, voided or expired")
+	ErrTradeExpired    = errors.New("core: trade expired before it was posted")
+)
+
+// FxTrade is a single exchange with a customer, booked as two linked TB transfers.
+// Following the usual bank convention, the branch liquidity accounts are assets and the customer's accounts are liabilities:
+// the customer is credited with the currency they hand over and debited with the currency paid out to them.
 type FxTrade struct {
 	// Stored in PG
-	TbPendingId   tbTypes.Uint128
-	CustomerId    uuid.UUID
-	OperatorId    uuid.UUID
-	ExchangeRate  decimal.Decimal
-	Direction     TradeDirection
-	CreatedAt     time.Time
-	Notes         string
-	IsUnusual     bool
-	UnusualReason string
+	TbPendingId      tbTypes.Uint128 // Also the ID of the credit leg
+	TbDebitPendingId tbTypes.Uint128
+	TbFeePendingId   tbTypes.Uint128 // Zero if no fee was charged
+	CustomerId       uuid.UUID
+	OperatorId       uuid.UUID
+	BranchId         uuid.UUID
+	TillId           uuid.UUID       // Nil if the trade was paid in and out of the safe
+	QuoteId          uuid.UUID       // Nil if the trade was booked at the current rate rather than from a quote
+	ExchangeRate     decimal.Decimal // The customer-facing rate, i.e. MidRate with Margin applied
+	MidRate          decimal.Decimal
+	Margin           Margin
+	// For CROSS trades, the Exchange rate is the BUY rate of the currency handed over and the Counter rate is the SELL rate
+	// of the currency paid out. Zero otherwise.
+	CounterExchangeRate decimal.Decimal
+	CounterMidRate      decimal.Decimal
+	CounterMargin       Margin
+	Direction           TradeDirection
+	State               TradeState
+	CreatedAt           time.Time
+	ExpiresAt           time.Time // Zero if the trade never expires
+	Notes               string
+	Fee                 uint64 // In the local currency's minor unit, credited to the branch fees account
+	IsUnusual           bool
+	UnusualReason       string
 
-	// Stored in TB
+	// Filled in from the TB legs
 	DebitLedger  Ledger
 	DebitAmount  uint64
 	CreditLedger Ledger
 	CreditAmount uint64
 }
 
-// TODO: implement
-func (c *Core) GetRate(ledger Ledger) (decimal.Decimal, error) {
-	return decimal.New(119, -2), nil
-}
-
+// LocalFromForeign converts an amount of a foreign currency to the local currency, rounding in the branch's favour.
+// Rates are quoted as units of foreign currency per unit of local currency.
 func (c *Core) LocalFromForeign(
+	ctx context.Context,
 	direction TradeDirection,
 	foreignAmount decimal.Decimal,
 	foreignLedger Ledger,
 ) (decimal.Decimal, error) {
-	localScale := CurrencyAssetScales[c.options.LocalCurrencyLedger]
+	rate, err := c.GetRate(ctx, foreignLedger, direction)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return c.localFromForeignAtRate(direction, foreignAmount, rate), nil
+}
 
-	rate, err := c.GetRate(foreignLedger)
+// ForeignFromLocal converts an amount of the local currency to a foreign currency, rounding in the branch's favour.
+func (c *Core) ForeignFromLocal(
+	ctx context.Context,
+	direction TradeDirection,
+	localAmount decimal.Decimal,
+	foreignLedger Ledger,
+) (decimal.Decimal, error) {
+	rate, err := c.GetRate(ctx, foreignLedger, direction)
 	if err != nil {
 		return decimal.Zero, err
 	}
 
+	return foreignFromLocalAtRate(direction, localAmount, foreignLedger, rate), nil
+}
+
+func (c *Core) localFromForeignAtRate(
+	direction TradeDirection,
+	foreignAmount decimal.Decimal,
+	rate decimal.Decimal,
+) decimal.Decimal {
+	localScale := CurrencyAssetScales[c.options.LocalCurrencyLedger]
+	localAmount := foreignAmount.DivRound(rate, HfxPrecision)
+
 	if direction == TradeSell {
 		// Ceil to nearest minor unit in our favour (see https://docs.tigerbeetle.com/single-page/#coding-recipes-currency-exchange)
-		return foreignAmount.DivRound(rate, HfxPrecision).RoundCeil(localScale), nil
+		return localAmount.RoundCeil(localScale)
 	} else {
 		// Floor in our favour
-		return foreignAmount.Mul(rate).RoundFloor(localScale), nil
+		return localAmount.RoundFloor(localScale)
 	}
 }
 
-func (c *Core) ForeignFromLocal(
+func foreignFromLocalAtRate(
 	direction TradeDirection,
 	localAmount decimal.Decimal,
 	foreignLedger Ledger,
-) (decimal.Decimal, error) {
+	rate decimal.Decimal,
+) decimal.Decimal {
 	foreignScale := CurrencyAssetScales[foreignLedger]
+	foreignAmount := localAmount.Mul(rate)
+
+	if direction == TradeSell {
+		return foreignAmount.RoundFloor(foreignScale)
+	} else {
+		return foreignAmount.RoundCeil(foreignScale)
+	}
+}
 
-	rate, err := c.GetRate(foreignLedger)
+type ExecuteTradeData struct {
+	CustomerId uuid.UUID
+	OperatorId uuid.UUID
+	Notes      string
+
+	// QuoteId books the trade at a quote's locked rate and amounts. If set, the fields below are ignored.
+	QuoteId uuid.UUID
+
+	Direction     TradeDirection
+	ForeignLedger Ledger
+	// ForeignAmount is in display units (e.g. 10.50 USD), and must not have more decimal places than the ledger's asset scale.
+	ForeignAmount decimal.Decimal
+	// CounterLedger is the currency paid out for a CROSS trade, in exchange for ForeignAmount of ForeignLedger.
+	CounterLedger Ledger
+}
+
+// ExecuteTrade books a trade with a customer, either from a quote or at the current rate. Both legs are created as linked
+// pending TB transfers against the branch liquidity accounts, and the trade is recorded in PG.
+// The customer's TB accounts are created if they have not traded in either currency before.
+func (c *Core) ExecuteTrade(ctx context.Context, data ExecuteTradeData) (*FxTrade, error) {
+	op, err := c.GetOperator(ctx, data.OperatorId, "")
 	if err != nil {
-		return decimal.Zero, err
+		return nil, err
+	}
+	if !op.IsActive {
+		return nil, ErrOperatorInactive
+	}
+	if _, err := c.GetCustomerById(ctx, data.CustomerId); err != nil {
+		return nil, err
 	}
 
-	if direction == TradeSell {
-		return localAmount.Mul(rate).RoundFloor(foreignScale), nil
+	var quote *Quote
+	if data.QuoteId != uuid.Nil {
+		quote, err = c.GetQuote(ctx, data.QuoteId)
+		if err != nil {
+			return nil, err
+		}
+		if quote.CustomerId != data.CustomerId {
+			return nil, ErrQuoteMismatch
+		}
+		if quote.TbPendingId != (tbTypes.Uint128{}) {
+			return nil, ErrQuoteUsed
+		}
+		if time.Now().After(quote.ExpiresAt) {
+			return nil, ErrQuoteExpired
+		}
 	} else {
-		return localAmount.DivRound(rate, HfxPrecision).RoundCeil(foreignScale), nil
+		quote, err = c.priceQuote(ctx, CreateQuoteData{
+			CustomerId:    data.CustomerId,
+			OperatorId:    data.OperatorId,
+			Direction:     data.Direction,
+			ForeignLedger: data.ForeignLedger,
+			ForeignAmount: data.ForeignAmount,
+			CounterLedger: data.CounterLedger,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.bookTrade(ctx, quote, op, data.Notes)
+}
+
+// bookTrade creates the TB transfers and PG row for a priced trade. If the quote has an Id, the trade is linked to it.
+// Cash is paid in and out of the operator's till, or the safe if they have none.
+func (c *Core) bookTrade(
+	ctx context.Context,
+	quote *Quote,
+	op *Operator,
+	notes string,
+) (*FxTrade, error) {
+	if err := c.checkBranchActive(ctx, op.BranchId); err != nil {
+		return nil, err
+	}
+	cust, err := c.checkCustomerCleared(ctx, quote.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	localLedger := c.options.LocalCurrencyLedger
+	ids, err := c.branchIds(ctx, op.BranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	foreignAccount, err := c.customerLedgerAccount(ctx, quote.CustomerId, quote.ForeignLedger)
+	if err != nil {
+		return nil, err
+	}
+	localAccount, err := c.customerLedgerAccount(ctx, quote.CustomerId, localLedger)
+	if err != nil {
+		return nil, err
+	}
+
+	foreignAmount, err := toMinorUnits(quote.ForeignAmount, quote.ForeignLedger)
+	if err != nil {
+		return nil, err
+	}
+	localAmount, err := toMinorUnits(quote.LocalAmount, localLedger)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := toMinorUnits(quote.Fee, localLedger)
+	if err != nil {
+		return nil, err
+	}
+	foreign := tradeSide{
+		ledger:    quote.ForeignLedger,
+		amount:    foreignAmount,
+		customer:  foreignAccount,
+		liquidity: c.liquidityId(ids, op.TillId, quote.ForeignLedger),
+	}
+	local := tradeSide{
+		ledger:    localLedger,
+		amount:    localAmount,
+		customer:  localAccount,
+		liquidity: c.liquidityId(ids, op.TillId, localLedger),
+	}
+
+	// BUY: the customer hands over foreign currency and is paid out in local currency. SELL is the reverse.
+	// The fee is taken from the customer's local account, so the local cash that changes hands is adjusted to cover it.
+	// CROSS: the customer hands over one foreign currency and is paid out in another, and the fee is paid in local cash.
+	var given, received tradeSide
+	feeDebitAccount := localAccount
+	switch quote.Direction {
+	case TradeBuy:
+		local.amount -= fee
+		given, received = foreign, local
+	case TradeSell:
+		local.amount += fee
+		given, received = local, foreign
+	case TradeCross:
+		counterAccount, err := c.customerLedgerAccount(ctx, quote.CustomerId, quote.CounterLedger)
+		if err != nil {
+			return nil, err
+		}
+		counterAmount, err := toMinorUnits(quote.CounterAmount, quote.CounterLedger)
+		if err != nil {
+			return nil, err
+		}
+		given = foreign
+		received = tradeSide{
+			ledger:    quote.CounterLedger,
+			amount:    counterAmount,
+			customer:  counterAccount,
+			liquidity: c.liquidityId(ids, op.TillId, quote.CounterLedger),
+		}
+		feeDebitAccount = local.liquidity
+	default:
+		return nil, ErrInvalidDirection
+	}
+
+	openLedgers := []Ledger{given.ledger, received.ledger}
+	if fee > 0 {
+		openLedgers = append(openLedgers, localLedger)
+	}
+	if err := c.checkLedgersTrading(ctx, c.pgc, op.BranchId, openLedgers...); err != nil {
+		return nil, err
+	}
+	if err := c.checkAvailableStock(received.ledger, received.liquidity, received.amount); err != nil {
+		return nil, err
+	}
+
+	// The customer stays locked until the trade is recorded, so concurrent trades are checked against each other's totals.
+	// The branch's day is held too, and checked again under it, so CloseDay can't count the cash halfway through.
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	if err := shareBranchDay(ctx, tx, op.BranchId); err != nil {
+		return nil, err
+	}
+	if err := c.checkLedgersTrading(ctx, tx, op.BranchId, openLedgers...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM customers WHERE id = $1 FOR NO KEY UPDATE", cust.Id); err != nil {
+		return nil, err
+	}
+	totals, err := c.checkCddThresholds(ctx, tx, cust, quote.LocalAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := uint32(c.options.TradeTimeout / time.Second)
+	creditLeg := tbTypes.Transfer{
+		ID:              tbTypes.ID(),
+		DebitAccountID:  given.liquidity,
+		CreditAccountID: given.customer,
+		Amount:          tbTypes.ToUint128(given.amount),
+		Timeout:         timeout,
+		Ledger:          uint32(given.ledger),
+		Code:            uint16(TransferCodeTrade),
+		Flags: tbTypes.TransferFlags{
+			Linked:  true,
+			Pending: true,
+		}.ToUint16(),
+	}
+	creditLeg.UserData128 = creditLeg.ID
+	debitLeg := tbTypes.Transfer{
+		ID:              tbTypes.ID(),
+		DebitAccountID:  received.customer,
+		CreditAccountID: received.liquidity,
+		Amount:          tbTypes.ToUint128(received.amount),
+		UserData128:     creditLeg.ID,
+		Timeout:         timeout,
+		Ledger:          uint32(received.ledger),
+		Code:            uint16(TransferCodeTrade),
+		Flags: tbTypes.TransferFlags{
+			Linked:  fee > 0,
+			Pending: true,
+		}.ToUint16(),
+	}
+	transfers := []tbTypes.Transfer{creditLeg, debitLeg}
+
+	var feeLeg tbTypes.Transfer
+	if fee > 0 {
+		feeLeg = tbTypes.Transfer{
+			ID:              tbTypes.ID(),
+			DebitAccountID:  feeDebitAccount,
+			CreditAccountID: ids.fees,
+			Amount:          tbTypes.ToUint128(fee),
+			UserData128:     creditLeg.ID,
+			Timeout:         timeout,
+			Ledger:          uint32(localLedger),
+			Code:            uint16(TransferCodeFee),
+			Flags: tbTypes.TransferFlags{
+				Pending: true,
+			}.ToUint16(),
+		}
+		transfers = append(transfers, feeLeg)
+	}
+
+	if err := c.createTransfers(transfers); err != nil {
+		return nil, c.stockError(err, received)
+	}
+
+	trade := &FxTrade{
+		TbPendingId:         creditLeg.ID,
+		TbDebitPendingId:    debitLeg.ID,
+		TbFeePendingId:      feeLeg.ID,
+		CustomerId:          quote.CustomerId,
+		OperatorId:          op.Id,
+		BranchId:            op.BranchId,
+		TillId:              op.TillId,
+		QuoteId:             quote.Id,
+		ExchangeRate:        quote.Rate.Rate,
+		MidRate:             quote.Rate.Mid,
+		Margin:              quote.Rate.Margin,
+		CounterExchangeRate: quote.CounterRate.Rate,
+		CounterMidRate:      quote.CounterRate.Mid,
+		CounterMargin:       quote.CounterRate.Margin,
+		Direction:           quote.Direction,
+		State:               TradePending,
+		Notes:               notes,
+		Fee:                 fee,
+		DebitLedger:         received.ledger,
+		DebitAmount:         received.amount,
+		CreditLedger:        given.ledger,
+		CreditAmount:        given.amount,
+	}
+
+	var expiresAt *time.Time
+	if timeout > 0 {
+		trade.ExpiresAt = time.Now().Add(time.Duration(timeout) * time.Second)
+		expiresAt = &trade.ExpiresAt
+	}
+	var quoteId *uuid.UUID
+	if quote.Id != uuid.Nil {
+		quoteId = &quote.Id
+	}
+	var feePendingId *uuid.UUID
+	if fee > 0 {
+		id := tbToUuid(feeLeg.ID)
+		feePendingId = &id
+	}
+	var tillId *uuid.UUID
+	if op.TillId != uuid.Nil {
+		tillId = &op.TillId
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		"INSERT INTO fx_trades (tb_pending_id, tb_debit_pending_id, tb_fee_pending_id, customer_id, operator_id, branch_id, till_id, quote_id, exchange_rate, mid_rate, margin_kind, margin_value, counter_exchange_rate, counter_mid_rate, counter_margin_kind, counter_margin_value, fee, direction, notes, expires_at, credit_ledger_id, credit_amount, debit_ledger_id, debit_amount, local_amount) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13::NUMERIC, 0), NULLIF($14::NUMERIC, 0), NULLIF($15::TEXT, ''), NULLIF($16::NUMERIC, 0), $17, $18, $19, $20, $21, $22, $23, $24, $25) RETURNING created_at",
+		tbToUuid(creditLeg.ID),
+		tbToUuid(debitLeg.ID),
+		feePendingId,
+		trade.CustomerId,
+		trade.OperatorId,
+		trade.BranchId,
+		tillId,
+		quoteId,
+		trade.ExchangeRate,
+		trade.MidRate,
+		trade.Margin.Kind,
+		trade.Margin.Value,
+		trade.CounterExchangeRate,
+		trade.CounterMidRate,
+		trade.CounterMargin.Kind,
+		trade.CounterMargin.Value,
+		trade.Fee,
+		trade.Direction,
+		trade.Notes,
+		expiresAt,
+		trade.CreditLedger,
+		trade.CreditAmount,
+		trade.DebitLedger,
+		trade.DebitAmount,
+		localAmount,
+	).Scan(&trade.CreatedAt)
+	if err == nil {
+		// The customer is reassessed with the trade counted before it is committed, so the trade is refused rather than
+		// leaving them on a stale rating for the next CDD check.
+		_, err = c.assessCustomerRisk(ctx, tx, cust, totals)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		// The trade can't be found without its PG row, so release the pending amounts rather than leaving them to dangle.
+		if voidErr := c.createTransfers(resolvePendingTransfers(true, transfers...)); voidErr != nil {
+			c.Logger.Error(
+				"failed to void pending trade transfers after PG write failed",
+				"tb_pending_id",
+				creditLeg.ID,
+				"error",
+				voidErr,
+			)
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation &&
+			pgErr.ConstraintName == "fx_trades_quote_id_key" {
+			return nil, ErrQuoteUsed
+		}
+		return nil, err
+	}
+
+	c.checkTradeStockLevels(ctx, op.BranchId, given, received)
+
+	c.Logger.Info(
+		"trade booked",
+		"tb_pending_id",
+		trade.TbPendingId,
+		"branch_id",
+		trade.BranchId,
+		"direction",
+		trade.Direction,
+		"credit_ledger",
+		given.ledger,
+		"debit_ledger",
+		received.ledger,
+		"credit_amount",
+		given.amount,
+		"debit_amount",
+		received.amount,
+		"fee",
+		fee,
+	)
+	return trade, nil
+}
+
+// tradeSide holds the accounts and amount (in minor units) for one currency of a trade.
+type tradeSide struct {
+	ledger    Ledger
+	amount    uint64
+	customer  tbTypes.Uint128
+	liquidity tbTypes.Uint128
+}
+
+// PostTrade posts every pending leg of a trade, e.g. once the teller has confirmed that cash has changed hands.
+// Returns ErrTradeExpired if the trade's timeout has passed, or ErrTradeNotPending if it was already posted or voided.
+func (c *Core) PostTrade(ctx context.Context, tbPendingId tbTypes.Uint128) error {
+	return c.resolveTrade(ctx, tbPendingId, false)
+}
+
+// VoidTrade voids every pending leg of a trade, releasing the amounts held against the branch and customer accounts.
+// Returns ErrTradeExpired if the trade's timeout has passed, or ErrTradeNotPending if it was already posted or voided.
+func (c *Core) VoidTrade(ctx context.Context, tbPendingId tbTypes.Uint128) error {
+	return c.resolveTrade(ctx, tbPendingId, true)
+}
+
+// ExpireTrades marks pending trades whose timeout has passed as EXPIRED in PG, returning how many were updated.
+// TB expires pending transfers on its own, so this only brings PG in line; it is safe to call periodically.
+func (c *Core) ExpireTrades(ctx context.Context) (int64, error) {
+	tag, err := c.pgc.Exec(
+		ctx,
+		"UPDATE fx_trades SET state = $1, resolved_at = expires_at WHERE state = $2 AND expires_at < NOW()",
+		TradeExpired,
+		TradePending,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// resolveTrade posts or voids a pending trade in TB and records the resulting state in PG.
+// The fx_trades row is locked for the duration so concurrent posts/voids of the same trade are serialised.
+func (c *Core) resolveTrade(ctx context.Context, tbPendingId tbTypes.Uint128, void bool) error {
+	tx, err := c.pgc.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var debitPendingId uuid.UUID
+	var feePendingId *uuid.UUID
+	var state TradeState
+	if err := tx.QueryRow(
+		ctx,
+		"SELECT tb_debit_pending_id, tb_fee_pending_id, state FROM fx_trades WHERE tb_pending_id = $1 FOR UPDATE",
+		tbToUuid(tbPendingId),
+	).Scan(&debitPendingId, &feePendingId, &state); err != nil {
+		return err
+	}
+	if state != TradePending {
+		return ErrTradeNotPending
+	}
+
+	pendingIds := []tbTypes.Uint128{tbPendingId, uuidToTb(debitPendingId)}
+	if feePendingId != nil {
+		pendingIds = append(pendingIds, uuidToTb(*feePendingId))
+	}
+	pending, err := c.tbc.LookupTransfers(pendingIds)
+	if err != nil {
+		return fmt.Errorf("core: failed to send lookup transfers request to TB: %w", err)
+	}
+	if len(pending) != len(pendingIds) {
+		return fmt.Errorf("core: pending transfers for trade %s not found in TB", tbPendingId)
+	}
+
+	newState := TradePosted
+	if void {
+		newState = TradeVoided
+	}
+
+	err = c.createTransfers(resolvePendingTransfers(void, pending...))
+	var transferErr *TransferError
+	if errors.As(err, &transferErr) {
+		switch transferErr.Result {
+		case tbTypes.TransferPendingTransferExpired:
+			newState = TradeExpired
+		case tbTypes.TransferPendingTransferAlreadyPosted:
+			// NOTE: PG is behind TB, e.g. a previous call failed after posting. Catch PG up instead of failing.
+			newState = TradePosted
+		case tbTypes.TransferPendingTransferAlreadyVoided:
+			newState = TradeVoided
+		default:
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		ctx,
+		"UPDATE fx_trades SET state = $1, resolved_at = NOW() WHERE tb_pending_id = $2",
+		newState,
+		tbToUuid(tbPendingId),
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	c.Logger.Info("trade resolved", "tb_pending_id", tbPendingId, "state", newState)
+
+	switch {
+	case newState == TradeExpired:
+		return ErrTradeExpired
+	case void != (newState == TradeVoided):
+		return ErrTradeNotPending
+	}
+	return nil
+}
+
+// resolvePendingTransfers builds a linked batch posting or voiding the given pending transfers in full.
+func resolvePendingTransfers(void bool, pending ...tbTypes.Transfer) []tbTypes.Transfer {
+	transfers := make([]tbTypes.Transfer, len(pending))
+	for i, p := range pending {
+		transfers[i] = tbTypes.Transfer{
+			ID:              tbTypes.ID(),
+			DebitAccountID:  p.DebitAccountID,
+			CreditAccountID: p.CreditAccountID,
+			Amount:          p.Amount,
+			PendingID:       p.ID,
+			Ledger:          p.Ledger,
+			Code:            p.Code,
+			Flags: tbTypes.TransferFlags{
+				Linked:              i < len(pending)-1,
+				PostPendingTransfer: !void,
+				VoidPendingTransfer: void,
+			}.ToUint16(),
+		}
+	}
+	return transfers
+}
+
+// fromMinorUnits converts an integer amount of the ledger's minor unit to a display amount, e.g. 1050 to 10.50 GBP.
+func fromMinorUnits(amount uint64, ledger Ledger) decimal.Decimal {
+	return decimal.NewFromBigInt(new(big.Int).SetUint64(amount), -CurrencyAssetScales[ledger])
+}
+
+// toMinorUnits converts a display amount to an integer amount of the ledger's minor unit, e.g. 10.50 GBP to 1050.
+// The amount must already be rounded to the ledger's asset scale. Negative amounts, and amounts too large for the int64
+// balances worked out from TB accounts, return ErrInvalidAmount rather than wrapping.
+func toMinorUnits(amount decimal.Decimal, ledger Ledger) (uint64, error) {
+	minor := amount.Shift(CurrencyAssetScales[ledger]).BigInt()
+	if minor.Sign() < 0 || !minor.IsInt64() {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidAmount, amount)
+	}
+	return minor.Uint64(), nil
+}
+
+// MaxTradesPage is the most trades ListTrades returns at once.
+const MaxTradesPage = 500
+
+// TradeFilter narrows down the trades listed by ListTrades. Zero fields match every trade.
+type TradeFilter struct {
+	CustomerId uuid.UUID
+	OperatorId uuid.UUID
+	BranchId   uuid.UUID
+	Direction  TradeDirection
+	DateRange  TimeRange
+	// UnusualOnly matches only trades marked as unusual.
+	UnusualOnly bool
+
+	// Ledger matches trades with a leg in the ledger, either handed over or paid out.
+	Ledger Ledger
+	// MinAmount and MaxAmount bound the amount of the leg in Ledger, or in the local currency if Ledger is zero, in display
+	// units. Both are inclusive. Trades without a leg in that ledger don't match.
+	MinAmount decimal.Decimal
+	MaxAmount decimal.Decimal
+}
+
+// TradeCursor marks where a page of ListTrades ended. The zero value starts from the newest trade.
+type TradeCursor struct {
+	CreatedAt   time.Time
+	TbPendingId tbTypes.Uint128
+}
+
+// GetTrade gets a trade from PG by its TbPendingId, along with its legs from TB.
+func (c *Core) GetTrade(ctx context.Context, tbPendingId tbTypes.Uint128) (*FxTrade, error) {
+	trade, err := scanTrade(c.pgc.QueryRow(ctx, sqlSelectTrades+" WHERE tb_pending_id = $1", tbToUuid(tbPendingId)))
+	if err != nil {
+		return nil, err
+	}
+
+	trades := []FxTrade{*trade}
+	if err := c.hydrateTrades(trades); err != nil {
+		return nil, err
+	}
+	return &trades[0], nil
+}
+
+// ListTrades lists trades matching a filter, newest first, along with their legs from TB. Up to limit trades are returned,
+// capped at MaxTradesPage, followed by the cursor to pass in for the next page. The returned cursor is zero once there are
+// no more trades.
+//
+// Ledgers and amounts are filtered on in SQL using the copies of the legs kept in fx_trades, but the legs returned are
+// always read from TB, which is the source of truth.
+func (c *Core) ListTrades(
+	ctx context.Context,
+	filter TradeFilter,
+	after TradeCursor,
+	limit int,
+) ([]FxTrade, TradeCursor, error) {
+	if limit <= 0 || limit > MaxTradesPage {
+		limit = MaxTradesPage
+	}
+
+	amountLedger := filter.Ledger
+	if amountLedger == 0 {
+		amountLedger = c.options.LocalCurrencyLedger
+	}
+	var minAmount, maxAmount uint64
+	if !filter.MinAmount.IsZero() {
+		if !validAmount(filter.MinAmount, amountLedger) {
+			return nil, TradeCursor{}, ErrInvalidAmount
+		}
+		var err error
+		if minAmount, err = toMinorUnits(filter.MinAmount, amountLedger); err != nil {
+			return nil, TradeCursor{}, err
+		}
+	}
+	if !filter.MaxAmount.IsZero() {
+		if !validAmount(filter.MaxAmount, amountLedger) {
+			return nil, TradeCursor{}, ErrInvalidAmount
+		}
+		var err error
+		if maxAmount, err = toMinorUnits(filter.MaxAmount, amountLedger); err != nil {
+			return nil, TradeCursor{}, err
+		}
+	}
+	from, to := filter.DateRange.bounds()
+	var afterCreatedAt *time.Time
+	var afterId *uuid.UUID
+	if !after.CreatedAt.IsZero() {
+		id := tbToUuid(after.TbPendingId)
+		afterCreatedAt, afterId = &after.CreatedAt, &id
+	}
+
+	rows, err := c.pgc.Query(
+		ctx,
+		sqlSelectTrades+" WHERE ($1::UUID IS NULL OR customer_id = $1) AND ($2::UUID IS NULL OR operator_id = $2) AND ($3::UUID IS NULL OR branch_id = $3) AND ($4::TEXT = '' OR direction = $4) AND ($5::TIMESTAMPTZ IS NULL OR created_at >= $5) AND ($6::TIMESTAMPTZ IS NULL OR created_at < $6) AND (NOT $7 OR is_unusual) AND ($8::TIMESTAMPTZ IS NULL OR (created_at, tb_pending_id) < ($8, $9::UUID)) AND ($10::INT = 0 OR credit_ledger_id = $10 OR debit_ledger_id = $10) AND (($12::BIGINT = 0 AND $13::BIGINT = 0) OR (credit_ledger_id = $11 AND credit_amount >= $12 AND ($13 = 0 OR credit_amount <= $13)) OR (debit_ledger_id = $11 AND debit_amount >= $12 AND ($13 = 0 OR debit_amount <= $13))) ORDER BY created_at DESC, tb_pending_id DESC LIMIT $14",
+		nullableId(filter.CustomerId),
+		nullableId(filter.OperatorId),
+		nullableId(filter.BranchId),
+		filter.Direction,
+		from,
+		to,
+		filter.UnusualOnly,
+		afterCreatedAt,
+		afterId,
+		filter.Ledger,
+		amountLedger,
+		minAmount,
+		maxAmount,
+		limit,
+	)
+	if err != nil {
+		return nil, TradeCursor{}, err
+	}
+	trades, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (FxTrade, error) {
+		trade, err := scanTrade(row)
+		if err != nil {
+			return FxTrade{}, err
+		}
+		return *trade, nil
+	})
+	if err != nil {
+		return nil, TradeCursor{}, err
+	}
+	if err := c.hydrateTrades(trades); err != nil {
+		return nil, TradeCursor{}, err
+	}
+
+	if len(trades) < limit {
+		return trades, TradeCursor{}, nil
+	}
+	last := trades[len(trades)-1]
+	return trades, TradeCursor{CreatedAt: last.CreatedAt, TbPendingId: last.TbPendingId}, nil
+}
+
+// backfillTradeLegs copies the TB legs of trades booked before fx_trades stored them, a batch at a time. It is called by
+// New, and does nothing once every trade has been filled in.
+func (c *Core) backfillTradeLegs(ctx context.Context) error {
+	for {
+		rows, err := c.pgc.Query(
+			ctx,
+			"SELECT tb_pending_id, tb_debit_pending_id FROM fx_trades WHERE credit_ledger_id IS NULL LIMIT $1",
+			MaxTradesPage,
+		)
+		if err != nil {
+			return err
+		}
+		var pendingIds, debitPendingIds []uuid.UUID
+		var pendingId, debitPendingId uuid.UUID
+		if _, err := pgx.ForEachRow(rows, []any{&pendingId, &debitPendingId}, func() error {
+			pendingIds = append(pendingIds, pendingId)
+			debitPendingIds = append(debitPendingIds, debitPendingId)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(pendingIds) == 0 {
+			return nil
+		}
+
+		ids := make([]tbTypes.Uint128, 0, len(pendingIds)*2)
+		for i := range pendingIds {
+			ids = append(ids, uuidToTb(pendingIds[i]), uuidToTb(debitPendingIds[i]))
+		}
+		transfers, err := c.lookupTransfers(ids)
+		if err != nil {
+			return err
+		}
+		legs := make(map[tbTypes.Uint128]tbTypes.Transfer, len(transfers))
+		for _, transfer := range transfers {
+			legs[transfer.ID] = transfer
+		}
+
+		creditLedgers := make([]int64, len(pendingIds))
+		creditAmounts := make([]int64, len(pendingIds))
+		debitLedgers := make([]int64, len(pendingIds))
+		debitAmounts := make([]int64, len(pendingIds))
+		for i := range pendingIds {
+			creditLeg, ok := legs[uuidToTb(pendingIds[i])]
+			if !ok {
+				return fmt.Errorf("core: credit leg of trade %s not found in TB", uuidToTb(pendingIds[i]))
+			}
+			debitLeg, ok := legs[uuidToTb(debitPendingIds[i])]
+			if !ok {
+				return fmt.Errorf("core: debit leg of trade %s not found in TB", uuidToTb(pendingIds[i]))
+			}
+			creditLedgers[i], creditAmounts[i] = int64(creditLeg.Ledger), int64(tbAmount(creditLeg.Amount))
+			debitLedgers[i], debitAmounts[i] = int64(debitLeg.Ledger), int64(tbAmount(debitLeg.Amount))
+		}
+
+		if _, err := c.pgc.Exec(
+			ctx,
+			"UPDATE fx_trades t SET credit_ledger_id = u.credit_ledger_id, credit_amount = u.credit_amount, debit_ledger_id = u.debit_ledger_id, debit_amount = u.debit_amount FROM unnest($1::UUID[], $2::INT[], $3::BIGINT[], $4::INT[], $5::BIGINT[]) AS u(id, credit_ledger_id, credit_amount, debit_ledger_id, debit_amount) WHERE t.tb_pending_id = u.id",
+			pendingIds,
+			creditLedgers,
+			creditAmounts,
+			debitLedgers,
+			debitAmounts,
+		); err != nil {
+			return err
+		}
+		c.Logger.Info("backfilled trade legs from TB", "trades", len(pendingIds))
+	}
+}
+
+const sqlSelectTrades = "SELECT tb_pending_id, tb_debit_pending_id, tb_fee_pending_id, customer_id, operator_id, branch_id, till_id, quote_id, exchange_rate, mid_rate, margin_kind, margin_value, COALESCE(counter_exchange_rate, 0), COALESCE(counter_mid_rate, 0), COALESCE(counter_margin_kind, ''), COALESCE(counter_margin_value, 0), direction, state, created_at, expires_at, notes, fee, is_unusual, COALESCE(unusual_reason, '') FROM fx_trades"
+
+// hydrateTrades fills in the TB legs of trades read from PG, looking every leg up at once. It fails if any leg is missing
+// from TB.
+func (c *Core) hydrateTrades(trades []FxTrade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+
+	ids := make([]tbTypes.Uint128, 0, len(trades)*2)
+	for _, trade := range trades {
+		ids = append(ids, trade.TbPendingId, trade.TbDebitPendingId)
+	}
+	transfers, err := c.lookupTransfers(ids)
+	if err != nil {
+		return err
+	}
+	legs := make(map[tbTypes.Uint128]tbTypes.Transfer, len(transfers))
+	for _, transfer := range transfers {
+		legs[transfer.ID] = transfer
+	}
+
+	for i := range trades {
+		creditLeg, ok := legs[trades[i].TbPendingId]
+		if !ok {
+			return fmt.Errorf("core: credit leg of trade %s not found in TB", trades[i].TbPendingId)
+		}
+		debitLeg, ok := legs[trades[i].TbDebitPendingId]
+		if !ok {
+			return fmt.Errorf("core: debit leg of trade %s not found in TB", trades[i].TbPendingId)
+		}
+		trades[i].CreditLedger = Ledger(creditLeg.Ledger)
+		trades[i].CreditAmount = tbAmount(creditLeg.Amount)
+		trades[i].DebitLedger = Ledger(debitLeg.Ledger)
+		trades[i].DebitAmount = tbAmount(debitLeg.Amount)
+	}
+
+	return nil
+}
+
+// scanTrade scans a row selected with sqlSelectTrades. The TB legs are left for hydrateTrades.
+func scanTrade(row pgx.Row) (*FxTrade, error) {
+	t := &FxTrade{}
+	var tbPendingId, tbDebitPendingId uuid.UUID
+	var tbFeePendingId, tillId, quoteId *uuid.UUID
+	var expiresAt *time.Time
+	if err := row.Scan(
+		&tbPendingId,
+		&tbDebitPendingId,
+		&tbFeePendingId,
+		&t.CustomerId,
+		&t.OperatorId,
+		&t.BranchId,
+		&tillId,
+		&quoteId,
+		&t.ExchangeRate,
+		&t.MidRate,
+		&t.Margin.Kind,
+		&t.Margin.Value,
+		&t.CounterExchangeRate,
+		&t.CounterMidRate,
+		&t.CounterMargin.Kind,
+		&t.CounterMargin.Value,
+		&t.Direction,
+		&t.State,
+		&t.CreatedAt,
+		&expiresAt,
+		&t.Notes,
+		&t.Fee,
+		&t.IsUnusual,
+		&t.UnusualReason,
+	); err != nil {
+		return nil, err
+	}
+
+	t.TbPendingId = uuidToTb(tbPendingId)
+	t.TbDebitPendingId = uuidToTb(tbDebitPendingId)
+	if tbFeePendingId != nil {
+		t.TbFeePendingId = uuidToTb(*tbFeePendingId)
+	}
+	if tillId != nil {
+		t.TillId = *tillId
+	}
+	if quoteId != nil {
+		t.QuoteId = *quoteId
+	}
+	if expiresAt != nil {
+		t.ExpiresAt = *expiresAt
 	}
+	return t, nil
 }
@@ -0,0 +1,257 @@
+//go:build integration
+
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	tbTypes "github.com/tigerbeetle/tigerbeetle-go/pkg/types"
+)
+
+// These tests book real trades, so they need a running TB cluster (see scripts/start-tigerbeetle.sh) and a PG database,
+// given by HFX_TEST_TB_ADDRESSES (comma separated) and HFX_TEST_PG_URL. They are skipped if either is unset. Run them with
+//
+//	go test -tags integration ./core
+//
+// Each test works in a new branch with new customers, so they can share a database, but it should be one kept for tests.
+
+// integrationTradeTimeout is the TradeTimeout of the test core, kept short so that trades can be left to expire.
+const integrationTradeTimeout = 2 * time.Second
+
+// testBranch is an open branch with an operator trading from its safe.
+type testBranch struct {
+	core     *Core
+	operator *Operator
+}
+
+func newTestBranch(t *testing.T) *testBranch {
+	t.Helper()
+	pgUrl := os.Getenv("HFX_TEST_PG_URL")
+	tbAddresses := os.Getenv("HFX_TEST_TB_ADDRESSES")
+	if pgUrl == "" || tbAddresses == "" {
+		t.Skip("HFX_TEST_PG_URL and HFX_TEST_TB_ADDRESSES are not set")
+	}
+	ctx := context.Background()
+
+	c, err := New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), Options{
+		TbAddresses:         strings.Split(tbAddresses, ","),
+		PgUrl:               pgUrl,
+		HfxDir:              t.TempDir(),
+		LocalCurrencyLedger: LedgerGBP,
+		TradeTimeout:        integrationTradeTimeout,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	branch, err := c.CreateBranch(ctx, "Test "+uuid.Must(uuid.NewV4()).String())
+	if err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	op, err := c.CreateOperator(ctx, CreateOperatorData{
+		Username: "test-" + uuid.Must(uuid.NewV4()).String(),
+		Password: []byte("password"),
+		BranchId: branch.Id,
+	})
+	if err != nil {
+		t.Fatalf("CreateOperator: %v", err)
+	}
+	if err := c.SetRate(ctx, LedgerUSD, decimal.RequireFromString("1.25"), op.Id); err != nil {
+		t.Fatalf("SetRate: %v", err)
+	}
+	if _, err := c.OpenDay(ctx, op.Id, map[Ledger]CashCount{
+		LedgerGBP: {Total: decimal.NewFromInt(1000)},
+		LedgerUSD: {Total: decimal.NewFromInt(1000)},
+	}); err != nil {
+		t.Fatalf("OpenDay: %v", err)
+	}
+
+	return &testBranch{core: c, operator: op}
+}
+
+func (b *testBranch) newCustomer(t *testing.T) *Customer {
+	t.Helper()
+	cust, err := b.core.CreateCustomer(context.Background(), CreateCustomerData{
+		FullName: "Test Customer " + uuid.Must(uuid.NewV4()).String(),
+		Address:  "1 Test Street",
+		Postcode: "SW1A 1AA",
+	})
+	if err != nil {
+		t.Fatalf("CreateCustomer: %v", err)
+	}
+	return cust
+}
+
+// trade books a trade in USD for a new customer, returning it and the customer's USD account.
+func (b *testBranch) trade(t *testing.T, direction TradeDirection, usd string) (*FxTrade, tbTypes.Uint128) {
+	t.Helper()
+	ctx := context.Background()
+
+	cust := b.newCustomer(t)
+	trade, err := b.core.ExecuteTrade(ctx, ExecuteTradeData{
+		CustomerId:    cust.Id,
+		OperatorId:    b.operator.Id,
+		Direction:     direction,
+		ForeignLedger: LedgerUSD,
+		ForeignAmount: decimal.RequireFromString(usd),
+	})
+	if err != nil {
+		t.Fatalf("ExecuteTrade: %v", err)
+	}
+	account, err := b.core.customerLedgerAccount(ctx, cust.Id, LedgerUSD)
+	if err != nil {
+		t.Fatalf("customerLedgerAccount: %v", err)
+	}
+	return trade, account
+}
+
+// checkState checks a trade's state in PG.
+func (b *testBranch) checkState(t *testing.T, trade *FxTrade, state TradeState) {
+	t.Helper()
+	got, err := b.core.GetTrade(context.Background(), trade.TbPendingId)
+	if err != nil {
+		t.Fatalf("GetTrade: %v", err)
+	}
+	if got.State != state {
+		t.Errorf("trade state = %s, want %s", got.State, state)
+	}
+}
+
+// checkAccount checks the pending and posted amounts the trade moved on the customer's USD account in TB: credits for a
+// BUY, where they hand dollars over, and debits for a SELL.
+func (b *testBranch) checkAccount(t *testing.T, trade *FxTrade, account tbTypes.Uint128, pending uint64, posted uint64) {
+	t.Helper()
+	accounts, err := b.core.tbc.LookupAccounts([]tbTypes.Uint128{account})
+	if err != nil || len(accounts) != 1 {
+		t.Fatalf("LookupAccounts: %v, found %d", err, len(accounts))
+	}
+	gotPending, gotPosted := accounts[0].CreditsPending, accounts[0].CreditsPosted
+	if trade.Direction == TradeSell {
+		gotPending, gotPosted = accounts[0].DebitsPending, accounts[0].DebitsPosted
+	}
+	if gotPending != tbTypes.ToUint128(pending) || gotPosted != tbTypes.ToUint128(posted) {
+		t.Errorf("customer USD account = %s pending, %s posted, want %d, %d", gotPending, gotPosted, pending, posted)
+	}
+}
+
+func TestTradePendingThenPosted(t *testing.T) {
+	b := newTestBranch(t)
+	ctx := context.Background()
+
+	for _, direction := range []TradeDirection{TradeBuy, TradeSell} {
+		t.Run(string(direction), func(t *testing.T) {
+			trade, account := b.trade(t, direction, "100")
+			b.checkState(t, trade, TradePending)
+			b.checkAccount(t, trade, account, 10000, 0)
+
+			if err := b.core.PostTrade(ctx, trade.TbPendingId); err != nil {
+				t.Fatalf("PostTrade: %v", err)
+			}
+			b.checkState(t, trade, TradePosted)
+			b.checkAccount(t, trade, account, 0, 10000)
+
+			if err := b.core.PostTrade(ctx, trade.TbPendingId); !errors.Is(err, ErrTradeNotPending) {
+				t.Errorf("PostTrade again: error = %v, want ErrTradeNotPending", err)
+			}
+			if err := b.core.VoidTrade(ctx, trade.TbPendingId); !errors.Is(err, ErrTradeNotPending) {
+				t.Errorf("VoidTrade after posting: error = %v, want ErrTradeNotPending", err)
+			}
+			b.checkState(t, trade, TradePosted)
+			b.checkAccount(t, trade, account, 0, 10000)
+		})
+	}
+}
+
+func TestTradePendingThenVoided(t *testing.T) {
+	b := newTestBranch(t)
+	ctx := context.Background()
+
+	for _, direction := range []TradeDirection{TradeBuy, TradeSell} {
+		t.Run(string(direction), func(t *testing.T) {
+			trade, account := b.trade(t, direction, "100")
+			b.checkState(t, trade, TradePending)
+			b.checkAccount(t, trade, account, 10000, 0)
+
+			if err := b.core.VoidTrade(ctx, trade.TbPendingId); err != nil {
+				t.Fatalf("VoidTrade: %v", err)
+			}
+			b.checkState(t, trade, TradeVoided)
+			b.checkAccount(t, trade, account, 0, 0)
+
+			if err := b.core.PostTrade(ctx, trade.TbPendingId); !errors.Is(err, ErrTradeNotPending) {
+				t.Errorf("PostTrade after voiding: error = %v, want ErrTradeNotPending", err)
+			}
+			b.checkState(t, trade, TradeVoided)
+			b.checkAccount(t, trade, account, 0, 0)
+		})
+	}
+}
+
+func TestTradeExpired(t *testing.T) {
+	b := newTestBranch(t)
+	ctx := context.Background()
+
+	posted, _ := b.trade(t, TradeBuy, "50")
+	swept, _ := b.trade(t, TradeBuy, "50")
+	time.Sleep(integrationTradeTimeout + time.Second)
+
+	// TB releases expired amounts in the background, so only the state is checked.
+
+	// Posting after the timeout finds TB has expired the legs, and catches PG up.
+	if err := b.core.PostTrade(ctx, posted.TbPendingId); !errors.Is(err, ErrTradeExpired) {
+		t.Fatalf("PostTrade after the timeout: error = %v, want ErrTradeExpired", err)
+	}
+	b.checkState(t, posted, TradeExpired)
+
+	// ExpireTrades catches PG up with the rest.
+	if _, err := b.core.ExpireTrades(ctx); err != nil {
+		t.Fatalf("ExpireTrades: %v", err)
+	}
+	b.checkState(t, swept, TradeExpired)
+	if err := b.core.VoidTrade(ctx, swept.TbPendingId); !errors.Is(err, ErrTradeNotPending) {
+		t.Errorf("VoidTrade after ExpireTrades: error = %v, want ErrTradeNotPending", err)
+	}
+}
+
+func TestTradeVoidedWhenPgWriteFails(t *testing.T) {
+	b := newTestBranch(t)
+	ctx := context.Background()
+
+	cust := b.newCustomer(t)
+	quote, err := b.core.CreateQuote(ctx, CreateQuoteData{
+		CustomerId:    cust.Id,
+		OperatorId:    b.operator.Id,
+		Direction:     TradeBuy,
+		ForeignLedger: LedgerUSD,
+		ForeignAmount: decimal.NewFromInt(100),
+	})
+	if err != nil {
+		t.Fatalf("CreateQuote: %v", err)
+	}
+	trade, err := b.core.ExecuteTrade(ctx, ExecuteTradeData{CustomerId: cust.Id, OperatorId: b.operator.Id, QuoteId: quote.Id})
+	if err != nil {
+		t.Fatalf("ExecuteTrade: %v", err)
+	}
+	account, err := b.core.customerLedgerAccount(ctx, cust.Id, LedgerUSD)
+	if err != nil {
+		t.Fatalf("customerLedgerAccount: %v", err)
+	}
+
+	// Booking the quote again gets past TB but fails on fx_trades_quote_id_key, as if two tellers had raced to use it, so
+	// its pending legs must be voided rather than left holding the amounts.
+	if _, err := b.core.bookTrade(ctx, quote, b.operator, ""); !errors.Is(err, ErrQuoteUsed) {
+		t.Fatalf("bookTrade with a used quote: error = %v, want ErrQuoteUsed", err)
+	}
+	b.checkState(t, trade, TradePending)
+	b.checkAccount(t, trade, account, 10000, 0)
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToMinorUnits(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  string
+		ledger  Ledger
+		want    uint64
+		wantErr bool
+	}{
+		{name: "whole pounds", amount: "10", ledger: LedgerGBP, want: 1000},
+		{name: "pounds and pence", amount: "10.50", ledger: LedgerGBP, want: 1050},
+		{name: "single penny", amount: "0.01", ledger: LedgerGBP, want: 1},
+		{name: "zero", amount: "0", ledger: LedgerGBP, want: 0},
+		{name: "no minor unit", amount: "1500", ledger: LedgerJPY, want: 1500},
+		{name: "three decimal places", amount: "1.234", ledger: LedgerKWD, want: 1234},
+		{name: "largest int64", amount: "92233720368547758.07", ledger: LedgerGBP, want: math.MaxInt64},
+		{name: "negative", amount: "-0.01", ledger: LedgerGBP, wantErr: true},
+		{name: "over int64", amount: "92233720368547758.08", ledger: LedgerGBP, wantErr: true},
+		{name: "over uint64", amount: "18446744073709551616", ledger: LedgerJPY, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toMinorUnits(decimal.RequireFromString(tt.amount), tt.ledger)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAmount) {
+					t.Fatalf("toMinorUnits(%s) error = %v, want ErrInvalidAmount", tt.amount, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toMinorUnits(%s) error = %v", tt.amount, err)
+			}
+			if got != tt.want {
+				t.Errorf("toMinorUnits(%s) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromMinorUnitsRoundTrip(t *testing.T) {
+	for _, ledger := range []Ledger{LedgerGBP, LedgerJPY, LedgerKWD} {
+		for _, minor := range []uint64{0, 1, 1050, math.MaxInt64} {
+			got, err := toMinorUnits(fromMinorUnits(minor, ledger), ledger)
+			if err != nil {
+				t.Fatalf("ledger %d: round trip of %d: %v", ledger, minor, err)
+			}
+			if got != minor {
+				t.Errorf("ledger %d: round trip of %d = %d", ledger, minor, got)
+			}
+		}
+	}
+}